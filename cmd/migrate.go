@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	var from, to string
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move the database content from one storage driver to another (stop the service first)",
+		Run: func(_ *cobra.Command, _ []string) {
+			wd, err := os.Getwd()
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			e := config.NewEnv(wd)
+
+			sealer, err := database.LoadSealer(e)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			source, err := database.NewStorage(from, e, sealer)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			defer func() {
+				_ = source.Close()
+			}()
+
+			target, err := database.NewStorage(to, e, sealer)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+			defer func() {
+				_ = target.Close()
+			}()
+
+			if !source.Exists() {
+				panic(fmt.Sprintf("no %s database found\n", from))
+			}
+			if target.Exists() {
+				panic(fmt.Sprintf("the %s database already exists\n", to))
+			}
+
+			content, err := database.Migrate(source, target)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			fmt.Printf("Migrated %d users and %d nodes from %s to %s.\n", len(content.Users), len(content.Nodes), from, to)
+			fmt.Printf("Set \"database.driver\" to \"%s\" in configs/main.json and restart the service.\n", to)
+		},
+	}
+
+	migrateCmd.Flags().StringVar(&from, "from", database.DriverJson, "source storage driver (json or bolt)")
+	migrateCmd.Flags().StringVar(&to, "to", database.DriverBolt, "target storage driver (json or bolt)")
+
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "rotate-key",
+		Short: "Encrypt the database and its backups with a new key (stop the service first)",
+		Run: func(_ *cobra.Command, _ []string) {
+			wd, err := os.Getwd()
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			c := config.New(config.NewEnv(wd))
+			if err = c.Init(); err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			key, skipped, err := database.RotateKey(c.Database.Driver, c.Env)
+			if err != nil {
+				panic(fmt.Sprintf("%+v\n", err))
+			}
+
+			for _, backup := range skipped {
+				fmt.Println("Skipped an unreadable backup:", backup)
+			}
+
+			if value := os.Getenv(config.DatabaseKeyEnv); value != "" {
+				fmt.Printf("Set %s to the new key below before starting the service:\n", config.DatabaseKeyEnv)
+				if len(skipped) > 0 {
+					// The skipped backups are still sealed with the old keys.
+					fmt.Println(database.EncodeKey(key) + "," + value)
+				} else {
+					fmt.Println(database.EncodeKey(key))
+				}
+			} else {
+				fmt.Println("The database key is rotated and saved in", c.Env.DatabaseKeyPath)
+				if len(skipped) > 0 {
+					fmt.Println("The old keys are kept in", c.Env.DatabaseKeyPath+".old")
+				}
+			}
+		},
+	})
+}
@@ -12,6 +12,7 @@ require (
 	github.com/labstack/gommon v0.4.2
 	github.com/miladrahimi/p-node v0.0.0-20250427174153-7afcca401666
 	github.com/spf13/cobra v1.9.1
+	go.etcd.io/bbolt v1.4.0
 	go.uber.org/zap v1.27.0
 )
 
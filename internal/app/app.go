@@ -3,6 +3,7 @@ package app
 import (
 	"context"
 	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/config"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
@@ -10,6 +11,8 @@ import (
 	"github.com/miladrahimi/p-manager/internal/http/client"
 	"github.com/miladrahimi/p-manager/internal/http/server"
 	"github.com/miladrahimi/p-manager/internal/licensor"
+	"github.com/miladrahimi/p-manager/internal/notifier"
+	"github.com/miladrahimi/p-manager/internal/tracker"
 	"github.com/miladrahimi/p-manager/internal/writer"
 	"github.com/miladrahimi/p-node/pkg/logger"
 	"github.com/miladrahimi/p-node/pkg/xray"
@@ -33,6 +36,9 @@ type App struct {
 	Xray        *xray.Xray
 	Enigma      *enigma.Enigma
 	Licensor    *licensor.Licensor
+	Auditor     *auditor.Auditor
+	Notifier    *notifier.Notifier
+	Tracker     *tracker.Tracker
 }
 
 func New() (a *App, err error) {
@@ -58,13 +64,20 @@ func New() (a *App, err error) {
 	e := a.Config.Env
 
 	a.Database = database.New(a.Logger, c)
+	a.Auditor = auditor.New(c, a.Logger)
 	a.Xray = xray.New(a.Context, a.Logger, c.Xray.LogLevel, e.XrayConfigPath, e.XrayBinaryPath)
 	a.HttpClient = client.New(c.HttpClient.Timeout, config.AppName, config.AppVersion)
 	a.Enigma = enigma.New(e.EnigmaKeyPath)
 	a.Licensor = licensor.New(c, a.HttpClient, a.Logger, a.Database, a.Enigma)
+	a.Notifier = notifier.New(a.Logger, a.HttpClient, a.Database)
+	a.Tracker = tracker.New(c, a.Logger)
 	a.Writer = writer.New(a.Config, a.Database, a.Xray)
-	a.Coordinator = coordinator.New(c, a.Context, a.HttpClient, a.Logger, a.Database, a.Xray, a.Writer)
-	a.HttpServer = server.New(c, a.Logger, a.Coordinator, a.Database, a.Enigma, a.Licensor, a.HttpClient)
+	a.Coordinator = coordinator.New(
+		c, a.Context, a.HttpClient, a.Logger, a.Database, a.Xray, a.Writer, a.Auditor, a.Notifier, a.Tracker,
+	)
+	a.HttpServer = server.New(
+		c, a.Logger, a.Coordinator, a.Database, a.Enigma, a.Licensor, a.HttpClient, a.Auditor, a.Tracker,
+	)
 
 	a.Logger.Info("app: constructed successfully")
 
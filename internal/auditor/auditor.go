@@ -0,0 +1,265 @@
+package auditor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"go.uber.org/zap"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// redacted replaces the values of sensitive fields in the recorded changes.
+const redacted = "[redacted]"
+
+// sensitiveFields are the JSON fields never written into the audit log.
+// The identities of users are secrets too, as they give access to their profiles.
+var sensitiveFields = []string{
+	"admin_password", "http_token", "shadowsocks_password", "telegram_token", "ss_server_key", "password",
+	"previous_password", "identity", "previous_identity",
+}
+
+// Change holds the values of a field before and after an action.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Entry is a record of an administrative action.
+type Entry struct {
+	Time    int64              `json:"time"`
+	Actor   string             `json:"actor"`
+	Ip      string             `json:"ip,omitempty"`
+	Action  string             `json:"action"`
+	Target  string             `json:"target,omitempty"`
+	Changes map[string]*Change `json:"changes,omitempty"`
+}
+
+// Filter selects entries of the audit log; zero fields match everything.
+type Filter struct {
+	Actor  string
+	Ip     string
+	Action string
+	Target string
+	From   int64
+	To     int64
+	Offset int
+	Limit  int
+}
+
+func (f *Filter) match(e *Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Ip == "" || e.Ip == f.Ip) &&
+		(f.Action == "" || strings.HasPrefix(e.Action, f.Action)) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.From == 0 || e.Time >= f.From) &&
+		(f.To == 0 || e.Time <= f.To)
+}
+
+// Auditor keeps an append-only log of administrative actions in a JSON-lines file.
+type Auditor struct {
+	l      *logger.Logger
+	c      *config.Config
+	locker *sync.Mutex
+}
+
+// Record appends the entry to the audit log.
+// Failures are logged only, since auditing must not break the audited action.
+func (a *Auditor) Record(e *Entry) {
+	if e.Time == 0 {
+		e.Time = time.Now().UnixMilli()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		a.l.Error("auditor: cannot marshal entry", zap.Error(errors.WithStack(err)))
+		return
+	}
+
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	file, err := os.OpenFile(a.c.Env.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		a.l.Error("auditor: cannot open log file", zap.Error(errors.WithStack(err)))
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		a.l.Error("auditor: cannot write entry", zap.Error(errors.WithStack(err)))
+	}
+}
+
+// Query returns the entries matching the filter, newest first, and the number of all matching entries.
+func (a *Auditor) Query(f *Filter) ([]*Entry, int, error) {
+	entries, err := a.read()
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	var matched []*Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if f.match(entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+
+	total := len(matched)
+	if f.Offset >= total {
+		return []*Entry{}, total, nil
+	}
+	matched = matched[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(matched) {
+		matched = matched[:f.Limit]
+	}
+
+	return matched, total, nil
+}
+
+// Prune removes the entries older than the retention period and the oldest ones beyond the maximum count.
+func (a *Auditor) Prune() error {
+	a.locker.Lock()
+	defer a.locker.Unlock()
+
+	entries, err := a.read()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	from := time.Now().AddDate(0, 0, -a.c.Audit.RetentionDays).UnixMilli()
+	kept := slices.DeleteFunc(slices.Clone(entries), func(e *Entry) bool {
+		return e.Time < from
+	})
+	if len(kept) > a.c.Audit.MaxEntries {
+		kept = kept[len(kept)-a.c.Audit.MaxEntries:]
+	}
+	if len(kept) == len(entries) {
+		return nil
+	}
+
+	var b bytes.Buffer
+	for _, e := range kept {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		b.Write(append(line, '\n'))
+	}
+
+	if err = utils.WriteFile(a.c.Env.AuditLogPath, b.Bytes(), 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	a.l.Info("auditor: log pruned", zap.Int("removed", len(entries)-len(kept)))
+	return nil
+}
+
+// read loads all the entries, oldest first; broken lines (like a torn last line) are skipped.
+func (a *Auditor) read() ([]*Entry, error) {
+	file, err := os.Open(a.c.Env.AuditLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, &e)
+	}
+
+	return entries, errors.WithStack(scanner.Err())
+}
+
+// Diff returns the changed fields between the JSON forms of two values.
+// Either value can be nil, as for created and deleted records. Sensitive fields are redacted.
+func Diff(before, after interface{}) map[string]*Change {
+	b, a := flatten(before), flatten(after)
+
+	changes := map[string]*Change{}
+	for k := range b {
+		if _, found := a[k]; !found {
+			changes[k] = &Change{Before: b[k]}
+		}
+	}
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			changes[k] = &Change{Before: b[k], After: v}
+		}
+	}
+
+	for k, c := range changes {
+		if slices.Contains(sensitiveFields, k) {
+			if c.Before != nil {
+				c.Before = redacted
+			}
+			if c.After != nil {
+				c.After = redacted
+			}
+		} else {
+			redact(c.Before)
+			redact(c.After)
+		}
+	}
+
+	return changes
+}
+
+// redact replaces the values of sensitive fields nested in the value, like the passwords of user credentials.
+func redact(v interface{}) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, nested := range value {
+			if slices.Contains(sensitiveFields, k) {
+				value[k] = redacted
+			} else {
+				redact(nested)
+			}
+		}
+	case []interface{}:
+		for _, nested := range value {
+			redact(nested)
+		}
+	}
+}
+
+func flatten(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return m
+	}
+	if j, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(j, &m)
+	}
+	return m
+}
+
+func New(c *config.Config, l *logger.Logger) *Auditor {
+	return &Auditor{c: c, l: l, locker: &sync.Mutex{}}
+}
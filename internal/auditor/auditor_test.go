@@ -0,0 +1,63 @@
+package auditor
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	type credential struct {
+		Password string `json:"password"`
+	}
+	type user struct {
+		Name                string        `json:"name"`
+		Identity            string        `json:"identity"`
+		PreviousIdentity    string        `json:"previous_identity"`
+		ShadowsocksPassword string        `json:"shadowsocks_password"`
+		PreviousPassword    string        `json:"previous_password"`
+		Credentials         []*credential `json:"credentials"`
+	}
+
+	before := &user{Name: "user", Identity: "old-identity", ShadowsocksPassword: "old-password"}
+	after := &user{
+		Name:                "renamed",
+		Identity:            "new-identity",
+		PreviousIdentity:    "old-identity",
+		ShadowsocksPassword: "new-password",
+		PreviousPassword:    "old-password",
+		Credentials:         []*credential{{Password: "credential-password"}},
+	}
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		fields []string
+	}{
+		{"created", nil, after, []string{"name", "identity", "previous_identity", "credentials"}},
+		{"updated", before, after, []string{"name", "identity", "previous_identity", "shadowsocks_password"}},
+		{"deleted", before, nil, []string{"name", "identity", "shadowsocks_password"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Diff(tt.before, tt.after)
+			for _, field := range tt.fields {
+				if changes[field] == nil {
+					t.Errorf("the change of %s is missing", field)
+				}
+			}
+
+			data, err := json.Marshal(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{"old-identity", "new-identity", "old-password", "new-password", "credential-password"} {
+				if strings.Contains(string(data), secret) {
+					t.Errorf("the changes leak %q: %s", secret, data)
+				}
+			}
+		})
+	}
+}
@@ -19,9 +19,19 @@ const Shadowsocks2022Method = "2022-blake3-aes-128-gcm"
 const FreeUsersCount = 16
 const MaxUsersCount = 1024
 
+const DatabaseKeyEnv = "P_MANAGER_DATABASE_KEY"
+
 const LicenseServer = "https://x.miladrahimi.com/p-manager/v1/servers"
 const LicenseToken = "Unauthorized"
 
+// HistoryRetention is the number of points kept for each granularity of a usage history.
+// Zero disables the granularity.
+type HistoryRetention struct {
+	FiveMinutes int `json:"five_minutes" validate:"min=0,max=8928"`
+	Hourly      int `json:"hourly" validate:"min=0,max=8784"`
+	Daily       int `json:"daily" validate:"min=0,max=3660"`
+}
+
 type Config struct {
 	Env        *Env `json:"-"`
 	HttpServer struct {
@@ -38,6 +48,41 @@ type Config struct {
 		Format string `json:"format" validate:"required,oneof='2006-01-02 15:04:05.000'"`
 	} `json:"logger" validate:"required"`
 
+	Database struct {
+		Driver string `json:"driver" validate:"required,oneof=json bolt"`
+		Backup struct {
+			Hourly  int `json:"hourly" validate:"min=0,max=720"`
+			Daily   int `json:"daily" validate:"min=0,max=366"`
+			Monthly int `json:"monthly" validate:"min=0,max=120"`
+			// Uploads, Restores, and Migrations are the numbers of the uploaded backups and the ones taken
+			// before restores and migrations kept, newest first; Legacy is for the backups of older versions.
+			Uploads    int  `json:"uploads" validate:"min=0,max=1000"`
+			Restores   int  `json:"restores" validate:"min=0,max=1000"`
+			Migrations int  `json:"migrations" validate:"min=0,max=1000"`
+			Legacy     int  `json:"legacy" validate:"min=0,max=1000"`
+			Compress   bool `json:"compress"`
+			// History includes the usage history of the users and nodes in the backups.
+			History bool `json:"history"`
+		} `json:"backup"`
+	} `json:"database" validate:"required"`
+
+	Audit struct {
+		RetentionDays int `json:"retention_days" validate:"required,min=1,max=3650"`
+		MaxEntries    int `json:"max_entries" validate:"required,min=100,max=10000000"`
+	} `json:"audit" validate:"required"`
+
+	History struct {
+		Users HistoryRetention `json:"users"`
+		Nodes HistoryRetention `json:"nodes"`
+		Total HistoryRetention `json:"total"`
+		// Periods is the number of the past reset periods archived for each user.
+		Periods int `json:"periods" validate:"min=0,max=1200"`
+		// Ledger is the number of the top-ups and usage adjustments kept for each user.
+		Ledger int `json:"ledger" validate:"min=0,max=10000"`
+		// Events is the number of the events (like quota warnings) kept for each user.
+		Events int `json:"events" validate:"min=0,max=10000"`
+	} `json:"history" validate:"required"`
+
 	Workers struct {
 		SyncStatsInterval int `json:"sync_stats_interval" validate:"required,min=10,max=3600"`
 	} `json:"workers" validate:"required"`
@@ -45,6 +90,17 @@ type Config struct {
 	Xray struct {
 		LogLevel string `json:"log_level" validate:"required,oneof=debug info warning error none"`
 	} `json:"xray" validate:"required"`
+
+	Ips struct {
+		// Window is the number of minutes an IP counts against the IP limit of its user after it is seen.
+		Window int `json:"window" validate:"required,min=1,max=1440"`
+		// Suspension is the number of minutes the users over their IP limits are disabled for.
+		Suspension int `json:"suspension" validate:"required,min=1,max=10080"`
+		// Retention is the number of hours the recent IPs of the users are kept.
+		Retention int `json:"retention" validate:"required,min=1,max=720"`
+		// MaxLogSize is the size (MB) of the Xray access log at which it is truncated after being read.
+		MaxLogSize int `json:"max_log_size" validate:"required,min=1,max=10240"`
+	} `json:"ips" validate:"required"`
 }
 
 func (c *Config) String() string {
@@ -11,15 +11,20 @@ var xrayBinaryPaths = map[string]string{
 }
 
 type Env struct {
-	AppDirectory       string
-	LicensePath        string
-	EnigmaKeyPath      string
-	XrayConfigPath     string
-	XrayBinaryPath     string
-	DefaultConfigPath  string
-	LocalConfigPath    string
-	DatabasePath       string
-	DatabaseBackupPath string
+	AppDirectory        string
+	LicensePath         string
+	EnigmaKeyPath       string
+	XrayConfigPath      string
+	XrayBinaryPath      string
+	XrayAccessLogPath   string
+	DefaultConfigPath   string
+	LocalConfigPath     string
+	DatabasePath        string
+	DatabaseBoltPath    string
+	DatabaseHistoryPath string
+	DatabaseBackupPath  string
+	DatabaseKeyPath     string
+	AuditLogPath        string
 }
 
 func NewEnv(appDirectory string) *Env {
@@ -29,14 +34,19 @@ func NewEnv(appDirectory string) *Env {
 	}
 
 	return &Env{
-		AppDirectory:       appDirectory,
-		XrayBinaryPath:     xrayBinaryPath,
-		DefaultConfigPath:  filepath.Join(appDirectory, "configs/main.defaults.json"),
-		LocalConfigPath:    filepath.Join(appDirectory, "configs/main.json"),
-		LicensePath:        filepath.Join(appDirectory, "storage/app/license.txt"),
-		EnigmaKeyPath:      filepath.Join(appDirectory, "resources/ed25519_public_key.txt"),
-		XrayConfigPath:     filepath.Join(appDirectory, "storage/app/xray.json"),
-		DatabasePath:       filepath.Join(appDirectory, "storage/database/app.json"),
-		DatabaseBackupPath: filepath.Join(appDirectory, "storage/database/backup-%s.json"),
+		AppDirectory:        appDirectory,
+		XrayBinaryPath:      xrayBinaryPath,
+		DefaultConfigPath:   filepath.Join(appDirectory, "configs/main.defaults.json"),
+		LocalConfigPath:     filepath.Join(appDirectory, "configs/main.json"),
+		LicensePath:         filepath.Join(appDirectory, "storage/app/license.txt"),
+		EnigmaKeyPath:       filepath.Join(appDirectory, "resources/ed25519_public_key.txt"),
+		XrayConfigPath:      filepath.Join(appDirectory, "storage/app/xray.json"),
+		XrayAccessLogPath:   filepath.Join(appDirectory, "storage/logs/xray-access.log"),
+		DatabasePath:        filepath.Join(appDirectory, "storage/database/app.json"),
+		DatabaseBoltPath:    filepath.Join(appDirectory, "storage/database/app.db"),
+		DatabaseHistoryPath: filepath.Join(appDirectory, "storage/database/history.json"),
+		DatabaseBackupPath:  filepath.Join(appDirectory, "storage/database/backup-%s.json"),
+		DatabaseKeyPath:     filepath.Join(appDirectory, "storage/app/database.key"),
+		AuditLogPath:        filepath.Join(appDirectory, "storage/database/audit.log"),
 	}
 }
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/config"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"github.com/miladrahimi/p-manager/internal/http/client"
+	"github.com/miladrahimi/p-manager/internal/notifier"
+	"github.com/miladrahimi/p-manager/internal/tracker"
 	"github.com/miladrahimi/p-manager/internal/utils"
 	"github.com/miladrahimi/p-manager/internal/writer"
 	"github.com/miladrahimi/p-node/pkg/logger"
@@ -16,17 +19,24 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Coordinator struct {
 	l        *logger.Logger
 	context  context.Context
+	cancel   context.CancelFunc
+	workers  *sync.WaitGroup
+	pausing  *sync.Mutex
 	config   *config.Config
 	database *database.Database
 	hc       *client.Client
 	xray     *xray.Xray
 	writer   *writer.Writer
+	auditor  *auditor.Auditor
+	notifier *notifier.Notifier
+	tracker  *tracker.Tracker
 }
 
 func (c *Coordinator) Run() {
@@ -34,7 +44,10 @@ func (c *Coordinator) Run() {
 
 	c.SyncConfigs()
 
-	go newWorker(c.context, time.Duration(c.config.Workers.SyncStatsInterval)*time.Second, func() {
+	ctx, cancel := context.WithCancel(c.context)
+	c.cancel = cancel
+
+	newWorker(ctx, c.workers, time.Duration(c.config.Workers.SyncStatsInterval)*time.Second, func() {
 		c.l.Info("coordinator: running worker for sync stats...")
 		if err := c.SyncStats(); err != nil {
 			c.l.Error("coordinator: cannot sync stats", zap.Error(errors.WithStack(err)))
@@ -43,21 +56,59 @@ func (c *Coordinator) Run() {
 		c.l.Debug("coordinator: worker for sync stats stopped")
 	}).Start()
 
-	go newWorker(c.context, time.Minute, func() {
+	newWorker(ctx, c.workers, time.Minute, func() {
 		c.l.Info("coordinator: running worker to sync outdated configs...")
 		c.syncOutdatedConfigs()
 	}, func() {
 		c.l.Debug("coordinator: worker for sync outdated configs stopped")
 	}).Start()
 
-	go newWorker(c.context, time.Hour, func() {
+	newWorker(ctx, c.workers, time.Minute, func() {
+		c.l.Info("coordinator: running worker to expire users...")
+		if err := c.expireUsers(); err != nil {
+			c.l.Error("coordinator: cannot expire users", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for expire users stopped")
+	}).Start()
+
+	newWorker(ctx, c.workers, time.Minute, func() {
+		c.l.Info("coordinator: running worker to expire grace periods...")
+		if err := c.expireGraces(); err != nil {
+			c.l.Error("coordinator: cannot expire grace periods", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for expire grace periods stopped")
+	}).Start()
+
+	newWorker(ctx, c.workers, time.Minute, func() {
+		c.l.Info("coordinator: running worker to limit users IPs...")
+		if err := c.limitIps(); err != nil {
+			c.l.Error("coordinator: cannot limit users IPs", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for limit users IPs stopped")
+	}).Start()
+
+	newWorker(ctx, c.workers, time.Hour, func() {
 		c.l.Info("coordinator: running worker to backup database...")
-		c.database.Backup()
+		if err := c.database.Backup(); err != nil {
+			c.l.Error("coordinator: cannot backup database", zap.Error(errors.WithStack(err)))
+		}
 	}, func() {
 		c.l.Debug("coordinator: worker for backup database stopped")
 	}).Start()
 
-	go newWorker(c.context, time.Hour, func() {
+	newWorker(ctx, c.workers, time.Hour, func() {
+		c.l.Info("coordinator: running worker to prune audit log...")
+		if err := c.auditor.Prune(); err != nil {
+			c.l.Error("coordinator: cannot prune audit log", zap.Error(errors.WithStack(err)))
+		}
+	}, func() {
+		c.l.Debug("coordinator: worker for prune audit log stopped")
+	}).Start()
+
+	newWorker(ctx, c.workers, time.Minute, func() {
 		c.l.Info("coordinator: running worker to reset users...")
 		if err := c.resetUserUsages(); err != nil {
 			c.l.Error("coordinator: cannot reset users usages", zap.Error(errors.WithStack(err)))
@@ -67,6 +118,27 @@ func (c *Coordinator) Run() {
 	}).Start()
 }
 
+// Stop stops the workers and waits for the running ones to finish.
+func (c *Coordinator) Stop() {
+	c.l.Info("coordinator: stopping workers...")
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.workers.Wait()
+}
+
+// Pause stops the workers while the given function runs, and runs them again afterward.
+// Concurrent calls run one at a time, so the workers are never started twice.
+func (c *Coordinator) Pause(f func() error) error {
+	c.pausing.Lock()
+	defer c.pausing.Unlock()
+
+	c.Stop()
+	defer c.Run()
+
+	return f()
+}
+
 func (c *Coordinator) SyncConfigs() {
 	c.l.Info("coordinator: syncing configs...")
 	if err := c.syncLocalConfig(); err != nil {
@@ -159,38 +231,100 @@ func (c *Coordinator) SyncStats() error {
 	c.database.Locker.Lock()
 	defer c.database.Locker.Unlock()
 
-	nodes := map[string]int64{}
-	users := map[string]int64{}
+	nodes := map[string]*traffic{}
+	users := map[string]*traffic{}
+	credentials := map[string]*traffic{}
+	total := &traffic{}
 
 	for _, qs := range queryStats {
+		// Stat names look like "user>>>1>>>traffic>>>uplink".
 		parts := strings.Split(qs.GetName(), ">>>")
+		direction := parts[len(parts)-1]
 		if parts[0] == "user" {
-			users[parts[1]] += qs.GetValue()
+			// The clients of the credentials are named "<user>.<credential>".
+			id, credential, _ := strings.Cut(parts[1], ".")
+			trafficOf(users, id).add(direction, qs.GetValue())
+			if credential != "" {
+				trafficOf(credentials, parts[1]).add(direction, qs.GetValue())
+			}
 		} else if parts[0] == "inbound" && strings.HasPrefix(parts[1], "internal-") {
-			nodes[parts[1][8:]] += qs.GetValue()
+			trafficOf(nodes, strings.TrimPrefix(parts[1], "internal-")).add(direction, qs.GetValue())
 		} else if parts[0] == "outbound" && strings.HasPrefix(parts[1], "relay-") {
-			nodes[parts[1][6:]] += qs.GetValue()
+			trafficOf(nodes, strings.TrimPrefix(parts[1], "relay-")).add(direction, qs.GetValue())
 		} else if parts[0] == "inbound" && slices.Contains([]string{"reverse", "relay", "direct"}, parts[1]) {
-			c.database.Content.Stats.TotalUsage += float64(qs.GetValue()) / 1000 / 1000 / 1000
+			total.add(direction, qs.GetValue())
 		}
 	}
 
+	stats := c.database.Content.Stats
+	stats.TotalUsage += float64(total.Uplink+total.Downlink) / 1000 / 1000 / 1000
+	stats.TotalUplink += float64(total.Uplink) / 1000 / 1000 / 1000
+	stats.TotalDownlink += float64(total.Downlink) / 1000 / 1000 / 1000
+
+	now := time.Now()
+	c.database.RecordTotalUsage(now, total.Uplink+total.Downlink)
+
+	changes := &database.Changes{Meta: true}
+
 	for _, s := range c.database.Content.Nodes {
-		if bytes, found := nodes[strconv.Itoa(s.Id)]; found {
-			s.Usage += utils.RoundFloat(float64(bytes)/1000/1000/1000, 2)
+		if t, found := nodes[strconv.Itoa(s.Id)]; found {
+			s.Usage += utils.RoundFloat(float64(t.Uplink+t.Downlink)/1000/1000/1000, 2)
+			s.Uplink += utils.RoundFloat(float64(t.Uplink)/1000/1000/1000, 2)
+			s.Downlink += utils.RoundFloat(float64(t.Downlink)/1000/1000/1000, 2)
+			c.database.RecordNodeUsage(s.Id, now, t.Uplink+t.Downlink)
+			changes.Nodes = append(changes.Nodes, s)
 		}
 	}
 
 	shouldSync := false
+	var notifications []*notifier.Notification
 	for _, u := range c.database.Content.Users {
-		if bytes, found := users[strconv.Itoa(u.Id)]; found {
-			u.UsageBytes += bytes
-			u.Usage = utils.RoundFloat(float64(u.UsageBytes)/1000/1000/1000, 2)
-			if u.Quota > 0 && u.Usage > u.Quota {
-				u.Enabled = false
-				shouldSync = true
-				c.l.Debug("coordinator: user disabled", zap.Int("id", u.Id))
+		if t, found := users[strconv.Itoa(u.Id)]; found {
+			changes.Users = append(changes.Users, u)
+			// Users are online while they have traffic in each stats sync.
+			u.Online = t.Uplink+t.Downlink > 0
+			if u.Online {
+				u.LastSeenAt = now.UnixMilli()
 			}
+			c.database.RecordUserUsage(u.Id, now, t.Uplink+t.Downlink)
+			u.AddTraffic(t.Uplink, t.Downlink)
+			for _, cr := range u.Credentials {
+				if t, found := credentials[database.ClientEmail(u, cr)]; found {
+					cr.AddTraffic(t.Uplink + t.Downlink)
+				}
+			}
+			if c.database.OverAllowance(u) && c.database.QuotaLimit(u) == database.QuotaLimitSoft {
+				if !u.QuotaExceeded {
+					u.QuotaExceeded = true
+					c.record("users.flag_quota", u.Id, map[string]*auditor.Change{
+						"quota_exceeded": {Before: false, After: true},
+					})
+					notifications = append(notifications, c.event(u, database.EventQuotaExceeded,
+						"The quota has been used up, and the user has been flagged (soft limit).", now))
+				}
+			} else if c.database.OverAllowance(u) {
+				// Disabled users may still be in the stats until the configs are synced.
+				if u.Enabled {
+					u.Disable(database.DisabledReasonQuota)
+					shouldSync = true
+					c.l.Debug("coordinator: user disabled", zap.Int("id", u.Id))
+					c.record("users.disable_quota", u.Id, map[string]*auditor.Change{
+						"enabled": {Before: true, After: false},
+					})
+					notifications = append(notifications, c.event(u, database.EventQuotaExceeded,
+						"The quota has been used up, and the user has been disabled.", now))
+				}
+			} else if warning := c.database.QuotaWarning(u); warning > 0 {
+				c.record("users.quota_warning", u.Id, map[string]*auditor.Change{
+					"quota_warning": {Before: u.QuotaWarning, After: warning},
+				})
+				u.QuotaWarning = warning
+				notifications = append(notifications, c.event(u, database.EventQuotaWarning,
+					fmt.Sprintf("%d%% of the quota has been used.", warning), now))
+			}
+		} else if u.Online {
+			u.Online = false
+			changes.Users = append(changes.Users, u)
 		}
 	}
 
@@ -198,36 +332,230 @@ func (c *Coordinator) SyncStats() error {
 		go c.SyncConfigs()
 	}
 
-	err = c.database.Save()
-	return errors.WithStack(err)
+	if err = c.database.Commit(changes); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go c.notifier.Notify(notifications...)
+
+	return nil
 }
 
-func (c *Coordinator) resetUserUsages() error {
-	if c.database.Content.Settings.ResetPolicy != "monthly" {
+// event records the event on the user and returns its notification.
+func (c *Coordinator) event(u *database.User, kind, message string, t time.Time) *notifier.Notification {
+	c.database.RecordUserEvent(u.Id, &database.Event{Type: kind, Message: message, Time: t.UnixMilli()})
+	return &notifier.Notification{
+		UserId:   u.Id,
+		UserName: u.Name,
+		Type:     kind,
+		Message:  message,
+		Time:     t.UnixMilli(),
+	}
+}
+
+// expireUsers disables the enabled users whose expiration dates have passed.
+func (c *Coordinator) expireUsers() error {
+	c.database.Locker.Lock()
+	defer c.database.Locker.Unlock()
+
+	now := time.Now()
+
+	var users []*database.User
+	var notifications []*notifier.Notification
+	for _, u := range c.database.Content.Users {
+		if u.Enabled && u.Expired(now) {
+			u.Disable(database.DisabledReasonExpired)
+			users = append(users, u)
+			notifications = append(notifications, c.event(u, database.EventExpired,
+				"The subscription has expired, and the user has been disabled.", now))
+			c.l.Debug("coordinator: user expired", zap.Int("id", u.Id))
+		}
+	}
+	if len(users) == 0 {
 		return nil
 	}
 
+	if err := c.database.Commit(&database.Changes{Users: users}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go c.notifier.Notify(notifications...)
+
+	for _, u := range users {
+		c.record("users.disable_expired", u.Id, map[string]*auditor.Change{
+			"enabled": {Before: true, After: false},
+		})
+	}
+
+	go c.SyncConfigs()
+
+	return nil
+}
+
+// expireGraces drops the previous identities and passwords of the users whose grace periods have passed.
+func (c *Coordinator) expireGraces() error {
+	c.database.Locker.Lock()
+	defer c.database.Locker.Unlock()
+
+	now := time.Now()
+
+	shouldSync := false
+	var users []*database.User
+	for _, u := range c.database.Content.Users {
+		before := *u
+		identity, password := u.ExpireGrace(now)
+		if !identity && !password {
+			continue
+		}
+		users = append(users, u)
+		shouldSync = shouldSync || password
+		c.record("users.expire_grace", u.Id, auditor.Diff(&before, u))
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	if err := c.database.SaveUsers(users...); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if shouldSync {
+		go c.SyncConfigs()
+	}
+
+	return nil
+}
+
+// limitIps follows the access log and enforces the IP limits of the users.
+// Users over their limits are disabled for the suspension time or only flagged, based on their IP limits.
+func (c *Coordinator) limitIps() error {
+	if err := c.tracker.Follow(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.database.Locker.Lock()
+	defer c.database.Locker.Unlock()
+
+	now := time.Now()
+	since := now.Add(-time.Duration(c.config.Ips.Window) * time.Minute)
+
+	shouldSync := false
+	var users []*database.User
+	var notifications []*notifier.Notification
+	for _, u := range c.database.Content.Users {
+		if !u.Enabled && u.DisabledReason == database.DisabledReasonIps {
+			if u.DisabledUntil > now.UnixMilli() {
+				continue
+			}
+			// Users expired meanwhile stay disabled, as if they were expired by the expiration worker.
+			if u.Expired(now) {
+				u.DisabledReason = database.DisabledReasonExpired
+			} else {
+				u.Enable()
+				shouldSync = true
+				c.record("users.enable_ips", u.Id, map[string]*auditor.Change{
+					"enabled": {Before: false, After: true},
+				})
+			}
+			u.DisabledUntil = 0
+			users = append(users, u)
+			continue
+		}
+
+		exceeded := u.Enabled && u.MaxIps > 0 && c.tracker.Count(u.Id, since) > u.MaxIps
+		if exceeded && c.database.IpLimit(u) == database.IpLimitDisable {
+			u.Disable(database.DisabledReasonIps)
+			u.DisabledUntil = now.Add(time.Duration(c.config.Ips.Suspension) * time.Minute).UnixMilli()
+			users = append(users, u)
+			shouldSync = true
+			c.record("users.disable_ips", u.Id, map[string]*auditor.Change{
+				"enabled": {Before: true, After: false},
+			})
+			notifications = append(notifications, c.event(u, database.EventIpsExceeded, fmt.Sprintf(
+				"The user has connected from more than %d IPs and has been disabled for %d minutes.",
+				u.MaxIps, c.config.Ips.Suspension,
+			), now))
+		} else if exceeded && !u.IpsExceeded {
+			u.IpsExceeded = true
+			users = append(users, u)
+			c.record("users.flag_ips", u.Id, map[string]*auditor.Change{
+				"ips_exceeded": {Before: false, After: true},
+			})
+			notifications = append(notifications, c.event(u, database.EventIpsExceeded, fmt.Sprintf(
+				"The user has connected from more than %d IPs and has been flagged.", u.MaxIps,
+			), now))
+		} else if !exceeded && u.IpsExceeded {
+			u.IpsExceeded = false
+			users = append(users, u)
+			c.record("users.unflag_ips", u.Id, map[string]*auditor.Change{
+				"ips_exceeded": {Before: true, After: false},
+			})
+		}
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	if err := c.database.Commit(&database.Changes{Users: users}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go c.notifier.Notify(notifications...)
+
+	if shouldSync {
+		go c.SyncConfigs()
+	}
+
+	return nil
+}
+
+// resetUserUsages resets the usages of the users whose reset periods have started, archiving the previous ones.
+func (c *Coordinator) resetUserUsages() error {
+	c.database.Locker.Lock()
+	defer c.database.Locker.Unlock()
+
 	c.l.Info("coordinator: resetting users usages...")
 
+	now := time.Now()
+
+	var users []*database.User
 	for _, u := range c.database.Content.Users {
-		if time.Unix(u.UsageResetAt, 0).Format("2006-01") == time.Now().Format("2006-01") {
+		if !c.database.ShouldResetUsage(u, now) {
 			continue
 		}
-		u.Usage = 0
-		u.UsageBytes = 0
-		u.Enabled = true
-		u.UsageResetAt = time.Now().Unix()
+		c.database.ResetUsage(u, now)
+		if !u.Enabled && u.DisabledReason == database.DisabledReasonQuota && !u.Expired(now) {
+			u.Enable()
+		}
+		users = append(users, u)
+	}
+	if len(users) == 0 {
+		return nil
 	}
 
-	if err := c.database.Save(); err != nil {
+	if err := c.database.Commit(&database.Changes{Users: users}); err != nil {
 		return errors.WithStack(err)
 	}
 
+	for _, u := range users {
+		c.record("users.reset_usage", u.Id, nil)
+	}
+
 	go c.SyncConfigs()
 
 	return nil
 }
 
+// record adds an action taken by the system itself to the audit log.
+func (c *Coordinator) record(action string, userId int, changes map[string]*auditor.Change) {
+	c.auditor.Record(&auditor.Entry{
+		Actor:   auditor.ActorSystem,
+		Action:  action,
+		Target:  fmt.Sprintf("user:%d", userId),
+		Changes: changes,
+	})
+}
+
 func New(
 	config *config.Config,
 	context context.Context,
@@ -236,6 +564,9 @@ func New(
 	database *database.Database,
 	xray *xray.Xray,
 	writer *writer.Writer,
+	auditor *auditor.Auditor,
+	notifier *notifier.Notifier,
+	tracker *tracker.Tracker,
 ) *Coordinator {
 	return &Coordinator{
 		l:        logger,
@@ -245,5 +576,33 @@ func New(
 		database: database,
 		xray:     xray,
 		writer:   writer,
+		auditor:  auditor,
+		notifier: notifier,
+		tracker:  tracker,
+		workers:  &sync.WaitGroup{},
+		pausing:  &sync.Mutex{},
+	}
+}
+
+// traffic is the bytes passed in each direction.
+type traffic struct {
+	Uplink   int64
+	Downlink int64
+}
+
+func (t *traffic) add(direction string, bytes int64) {
+	if direction == "uplink" {
+		t.Uplink += bytes
+	} else {
+		t.Downlink += bytes
+	}
+}
+
+func trafficOf(m map[string]*traffic, key string) *traffic {
+	t, found := m[key]
+	if !found {
+		t = &traffic{}
+		m[key] = t
 	}
+	return t
 }
@@ -2,11 +2,13 @@ package coordinator
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
 type Worker struct {
 	context  context.Context
+	wg       *sync.WaitGroup
 	interval time.Duration
 	body     func()
 	callback func()
@@ -14,7 +16,10 @@ type Worker struct {
 
 func (w *Worker) Start() {
 	ticker := time.NewTicker(w.interval)
+	w.wg.Add(1)
 	go func() {
+		defer w.wg.Done()
+		defer ticker.Stop()
 		for {
 			select {
 			case <-w.context.Done():
@@ -27,6 +32,6 @@ func (w *Worker) Start() {
 	}()
 }
 
-func newWorker(c context.Context, interval time.Duration, body func(), callback func()) *Worker {
-	return &Worker{context: c, interval: interval, body: body, callback: callback}
+func newWorker(c context.Context, wg *sync.WaitGroup, interval time.Duration, body func(), callback func()) *Worker {
+	return &Worker{context: c, wg: wg, interval: interval, body: body, callback: callback}
 }
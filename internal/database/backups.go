@@ -0,0 +1,385 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLayout names the scheduled backups, so they can be grouped by hour, day and month.
+const backupLayout = "20060102-15"
+
+// BackupInfo describes a backup file.
+type BackupInfo struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Time    int64  `json:"time"`
+	Valid   bool   `json:"valid"`
+	Version int    `json:"version"`
+	Users   int    `json:"users"`
+	Nodes   int    `json:"nodes"`
+}
+
+// Backup writes a scheduled backup of the content and prunes the old ones by the retention policy.
+func (d *Database) Backup() error {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	path, err := d.writeBackup(time.Now().Format(backupLayout), d.Content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	d.l.Debug("database: backup saved", zap.String("file", path))
+
+	return errors.WithStack(d.pruneBackups())
+}
+
+// Backups describes the backup files, newest first.
+func (d *Database) Backups() ([]*BackupInfo, error) {
+	paths, err := d.backups()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var backups []*BackupInfo
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		info := &BackupInfo{Name: filepath.Base(path), Size: stat.Size(), Time: stat.ModTime().UnixMilli()}
+		if content, err := d.read(path); err == nil {
+			info.Valid = true
+			info.Version = content.Version
+			info.Users = len(content.Users)
+			info.Nodes = len(content.Nodes)
+		}
+		backups = append(backups, info)
+	}
+
+	return backups, nil
+}
+
+// BackupPath returns the path of the backup file with the given name.
+// It only accepts names of existing backups, so the name cannot point outside the backup directory.
+func (d *Database) BackupPath(name string) (string, bool) {
+	paths, err := d.backups()
+	if err != nil {
+		return "", false
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == name {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// ReadBackup loads and validates the content of the backup file with the given name.
+func (d *Database) ReadBackup(name string) (*Content, error) {
+	path, found := d.BackupPath(name)
+	if !found {
+		return nil, errors.Errorf("backup %s not found", name)
+	}
+	content, err := d.read(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return content, errors.WithStack(validateRows(content))
+}
+
+// StoreBackup validates an uploaded backup (plain or compressed) and stores it next to the other backups.
+func (d *Database) StoreBackup(data []byte) (*BackupInfo, error) {
+	content, err := d.decodeBackup(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = validateRows(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	path, err := d.writeBackup("upload-"+time.Now().Format("20060102-150405"), content)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &BackupInfo{
+		Name:    filepath.Base(path),
+		Size:    stat.Size(),
+		Time:    stat.ModTime().UnixMilli(),
+		Valid:   true,
+		Version: content.Version,
+		Users:   len(content.Users),
+		Nodes:   len(content.Nodes),
+	}, nil
+}
+
+// validateRows validates every user and node, which the content validation skips.
+func validateRows(content *Content) error {
+	v := validator.New()
+	for _, u := range content.Users {
+		if err := v.Struct(u); err != nil {
+			return errors.Wrapf(err, "invalid user #%d", u.Id)
+		}
+	}
+	for _, n := range content.Nodes {
+		if err := v.Struct(n); err != nil {
+			return errors.Wrapf(err, "invalid node #%d", n.Id)
+		}
+	}
+	return nil
+}
+
+// Restore replaces the whole content with the given one, keeping a backup of the current content first.
+// The current history is kept when the backup has none.
+// The caller must hold the lock and resync the configs afterward.
+func (d *Database) Restore(content *Content) error {
+	path, err := d.writeBackup("restore-"+time.Now().Format("20060102-150405"), d.Content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	d.l.Info("database: backed up before restore", zap.String("file", path))
+
+	if content.History == nil {
+		content.History = d.Content.History
+	}
+
+	// The content is replaced only once it is saved, so a failed restore leaves the current one in place.
+	d.migrate(content)
+	if err = d.storage.Save(content); err != nil {
+		return errors.WithStack(err)
+	}
+	d.Content = content
+
+	return nil
+}
+
+// writeBackup writes the content into the backup file of the given name, compressed if configured.
+// The history is left out unless configured, as it is the largest and least critical part of the content.
+func (d *Database) writeBackup(name string, content *Content) (string, error) {
+	if !d.c.Database.Backup.History {
+		c := *content
+		c.History = nil
+		content = &c
+	}
+
+	data, err := encodeFile(content, d.sealer)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	path := fmt.Sprintf(d.c.Env.DatabaseBackupPath, name)
+	if d.c.Database.Backup.Compress {
+		path += ".gz"
+		if data, err = compress(data); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	return path, errors.WithStack(utils.WriteFile(path, data, 0600))
+}
+
+// pruneBackups keeps the newest scheduled backup of each of the last hours, days and months
+// (as many as the retention policy allows), and the newest ones of each of the other kinds
+// (as many as their counts allow), and removes the rest.
+func (d *Database) pruneBackups() error {
+	paths, err := d.backups()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var scheduled []string
+	times := map[string]time.Time{}
+	for _, path := range paths {
+		if t, ok := d.backupTime(path); ok {
+			scheduled = append(scheduled, path)
+			times[path] = t
+		}
+	}
+
+	// The other kinds are kept by count, and the paths are already sorted newest first.
+	keep := map[string]bool{}
+	counts := map[string]int{
+		backupUpload:    d.c.Database.Backup.Uploads,
+		backupRestore:   d.c.Database.Backup.Restores,
+		backupMigration: d.c.Database.Backup.Migrations,
+		backupLegacy:    d.c.Database.Backup.Legacy,
+	}
+	var others []string
+	for _, path := range paths {
+		if kind := d.backupKind(path); kind != backupScheduled {
+			others = append(others, path)
+			if counts[kind] > 0 {
+				keep[path] = true
+				counts[kind]--
+			}
+		}
+	}
+
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return times[scheduled[i]].After(times[scheduled[j]])
+	})
+
+	policies := []struct {
+		layout string
+		count  int
+	}{
+		{"2006010215", d.c.Database.Backup.Hourly},
+		{"20060102", d.c.Database.Backup.Daily},
+		{"200601", d.c.Database.Backup.Monthly},
+	}
+	for _, p := range policies {
+		seen := map[string]bool{}
+		for _, path := range scheduled {
+			period := times[path].Format(p.layout)
+			if seen[period] {
+				continue
+			}
+			if len(seen) == p.count {
+				break
+			}
+			seen[period] = true
+			keep[path] = true
+		}
+	}
+
+	for _, path := range append(scheduled, others...) {
+		if keep[path] {
+			continue
+		}
+		if err = os.Remove(path); err != nil {
+			return errors.WithStack(err)
+		}
+		d.l.Debug("database: backup pruned", zap.String("file", path))
+	}
+
+	return nil
+}
+
+// The kinds of the backups, by the names they are written with.
+const (
+	backupScheduled = "scheduled"
+	backupUpload    = "upload"
+	backupRestore   = "restore"
+	backupMigration = "migration"
+	// backupLegacy is any other backup, like the weekday-hour ones of older versions.
+	backupLegacy = "legacy"
+)
+
+// backupKind returns the kind of the backup from its file name.
+func (d *Database) backupKind(path string) string {
+	if _, ok := d.backupTime(path); ok {
+		return backupScheduled
+	}
+	prefix, _, _ := strings.Cut(filepath.Base(d.c.Env.DatabaseBackupPath), "%s")
+	name := strings.TrimPrefix(filepath.Base(path), prefix)
+	switch {
+	case strings.HasPrefix(name, "upload-"):
+		return backupUpload
+	case strings.HasPrefix(name, "restore-"):
+		return backupRestore
+	case migrationBackupName.MatchString(name):
+		return backupMigration
+	default:
+		return backupLegacy
+	}
+}
+
+// migrationBackupName matches the names of the backups taken before migrations, "v<version>-<time>".
+var migrationBackupName = regexp.MustCompile(`^v\d+-`)
+
+// backupTime parses the time of a scheduled backup from its file name.
+func (d *Database) backupTime(path string) (time.Time, bool) {
+	prefix, suffix, _ := strings.Cut(filepath.Base(d.c.Env.DatabaseBackupPath), "%s")
+	name := strings.TrimSuffix(filepath.Base(path), ".gz")
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(backupLayout, name[len(prefix):len(name)-len(suffix)], time.Local)
+	return t, err == nil
+}
+
+// compress gzips the data.
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b.Bytes(), nil
+}
+
+// isCompressed checks the gzip magic number.
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0x1f, 0x8b})
+}
+
+// decompress unzips gzip data and returns any other data as it is.
+func decompress(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	data, err = io.ReadAll(r)
+	return data, errors.WithStack(err)
+}
+
+// backups returns the backup files (plain or compressed), newest first.
+func (d *Database) backups() ([]string, error) {
+	return backupPaths(d.c.Env)
+}
+
+func backupPaths(env *config.Env) ([]string, error) {
+	pattern := fmt.Sprintf(env.DatabaseBackupPath, "*")
+
+	plain, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	compressed, err := filepath.Glob(pattern + ".gz")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	paths := append(plain, compressed...)
+
+	times := map[string]time.Time{}
+	for _, path := range paths {
+		if stat, err := os.Stat(path); err == nil {
+			times[path] = stat.ModTime()
+		}
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		return times[paths[i]].After(times[paths[j]])
+	})
+
+	return paths, nil
+}
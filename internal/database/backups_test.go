@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeTestBackup writes a backup of the given name with the given users and modification time.
+func writeTestBackup(t *testing.T, d *Database, name string, users int, age time.Duration) string {
+	t.Helper()
+
+	content := newContent()
+	content.Version = SchemaVersion()
+	for i := 1; i <= users; i++ {
+		content.Users = append(content.Users, newTestUser(d, i))
+	}
+
+	path, err := d.writeBackup(name, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, time.Now().Add(-age), time.Now().Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestFile writes an invalid backup of the given name with the given modification time.
+func writeTestFile(t *testing.T, d *Database, name, data string, age time.Duration) {
+	t.Helper()
+
+	path := fmt.Sprintf(d.c.Env.DatabaseBackupPath, name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now().Add(-age), time.Now().Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverOrder(t *testing.T) {
+	testRecover(t, []recoverTest{
+		{
+			name:   "scheduled backups before newer other ones",
+			driver: DriverBolt,
+			write: func(t *testing.T, d *Database) {
+				writeTestBackup(t, d, "20200101-00", 1, 3*time.Hour)
+				writeTestBackup(t, d, "restore-20200101-000000", 2, 2*time.Hour)
+				writeTestBackup(t, d, "upload-20200101-000000", 3, time.Hour)
+			},
+			users: 1,
+		},
+		{
+			name:   "backups taken by the application after invalid scheduled ones",
+			driver: DriverJson,
+			write: func(t *testing.T, d *Database) {
+				writeTestFile(t, d, "20200101-00", `{"checksum":"0","content":{}}`, time.Hour)
+				writeTestBackup(t, d, "v1-20200101-000000", 2, 2*time.Hour)
+				writeTestBackup(t, d, "upload-20200101-000000", 3, time.Hour)
+			},
+			users: 2,
+		},
+		{
+			name:   "uploaded backups only",
+			driver: DriverJson,
+			write: func(t *testing.T, d *Database) {
+				writeTestBackup(t, d, "upload-20200101-000000", 3, time.Hour)
+			},
+			wantErr: true,
+		},
+	})
+}
+
+func TestPruneBackups(t *testing.T) {
+	hour := time.Date(2020, 1, 31, 23, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name   string
+		config func(d *Database)
+		files  []string
+		want   []string
+	}{
+		{
+			name: "hourly, daily, and monthly",
+			config: func(d *Database) {
+				d.c.Database.Backup.Hourly, d.c.Database.Backup.Daily, d.c.Database.Backup.Monthly = 2, 2, 2
+			},
+			files: []string{"20200131-23", "20200131-22", "20200131-21", "20200130-10", "20200129-10", "20191231-10"},
+			want:  []string{"20200131-23", "20200131-22", "20200130-10", "20191231-10"},
+		},
+		{
+			name: "nothing of a kind",
+			config: func(d *Database) {
+				d.c.Database.Backup.Hourly, d.c.Database.Backup.Daily, d.c.Database.Backup.Monthly = 1, 0, 0
+				d.c.Database.Backup.Uploads = 0
+			},
+			files: []string{"20200131-23", "20200131-22", "upload-20200131-100000"},
+			want:  []string{"20200131-23"},
+		},
+		{
+			name: "other kinds by count",
+			config: func(d *Database) {
+				d.c.Database.Backup.Uploads, d.c.Database.Backup.Restores = 1, 2
+				d.c.Database.Backup.Migrations, d.c.Database.Backup.Legacy = 1, 1
+			},
+			files: []string{
+				"upload-20200131-100000", "upload-20200130-100000",
+				"restore-20200131-100000", "restore-20200130-100000", "restore-20200129-100000",
+				"v5-20200131-100000", "v4-20200130-100000",
+				"mon-10", "sun-10",
+			},
+			want: []string{
+				"upload-20200131-100000",
+				"restore-20200131-100000", "restore-20200130-100000",
+				"v5-20200131-100000",
+				"mon-10",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t, DriverJson)
+			tt.config(d)
+
+			// The files are written newest first, an hour apart.
+			for i, name := range tt.files {
+				writeTestFile(t, d, name, "{}", time.Since(hour)+time.Duration(i)*time.Hour)
+			}
+
+			if err := d.pruneBackups(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := d.backups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, fmt.Sprintf(d.c.Env.DatabaseBackupPath, name))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("kept %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+var (
+	boltMetaBucket         = []byte("meta")
+	boltUsersBucket        = []byte("users")
+	boltNodesBucket        = []byte("nodes")
+	boltUsersHistoryBucket = []byte("users_history")
+	boltNodesHistoryBucket = []byte("nodes_history")
+	boltMetaKey            = []byte("content")
+	boltTotalHistoryKey    = []byte("total_history")
+)
+
+// BoltStorage keeps the content in an embedded transactional bbolt store.
+// Users and nodes are stored as separate rows, and the rest of the content is stored as one meta document.
+// The history of each user and node is stored as a separate row too, and the total one next to the meta document.
+type BoltStorage struct {
+	path   string
+	db     *bolt.DB
+	sealer *Sealer
+}
+
+func (s *BoltStorage) open() error {
+	if s.db != nil {
+		return nil
+	}
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.db = db
+	return nil
+}
+
+func (s *BoltStorage) Exists() bool {
+	return utils.FileExist(s.path)
+}
+
+func (s *BoltStorage) Load(content *Content) error {
+	if err := s.open(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta == nil {
+			return errors.New("meta bucket not found")
+		}
+		if err := json.Unmarshal(meta.Get(boltMetaKey), content); err != nil {
+			return errors.WithStack(err)
+		}
+
+		users := tx.Bucket(boltUsersBucket)
+		if users == nil {
+			return errors.New("users bucket not found")
+		}
+		content.Users = []*User{}
+		if err := users.ForEach(func(_, v []byte) error {
+			user := &User{}
+			content.Users = append(content.Users, user)
+			return json.Unmarshal(v, user)
+		}); err != nil {
+			return errors.WithStack(err)
+		}
+
+		nodes := tx.Bucket(boltNodesBucket)
+		if nodes == nil {
+			return errors.New("nodes bucket not found")
+		}
+		content.Nodes = []*Node{}
+		if err := nodes.ForEach(func(_, v []byte) error {
+			node := &Node{}
+			content.Nodes = append(content.Nodes, node)
+			return json.Unmarshal(v, node)
+		}); err != nil {
+			return errors.WithStack(err)
+		}
+
+		return s.sealer.OpenContent(content)
+	}))
+}
+
+func (s *BoltStorage) LoadHistory(history *History) error {
+	if err := s.open(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.db.View(func(tx *bolt.Tx) error {
+		// The stores written before the history was stored apart have none of it.
+		if meta := tx.Bucket(boltMetaBucket); meta != nil && meta.Get(boltTotalHistoryKey) != nil {
+			total := &Series{}
+			if err := json.Unmarshal(meta.Get(boltTotalHistoryKey), total); err != nil {
+				return errors.WithStack(err)
+			}
+			history.Total = total
+		}
+
+		if users := tx.Bucket(boltUsersHistoryBucket); users != nil {
+			if err := users.ForEach(func(k, v []byte) error {
+				uh := &UserHistory{}
+				if err := json.Unmarshal(v, uh); err != nil {
+					return errors.WithStack(err)
+				}
+				history.setUser(int(binary.BigEndian.Uint64(k)), uh)
+				return nil
+			}); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		if nodes := tx.Bucket(boltNodesHistoryBucket); nodes != nil {
+			if err := nodes.ForEach(func(k, v []byte) error {
+				series := &Series{}
+				if err := json.Unmarshal(v, series); err != nil {
+					return errors.WithStack(err)
+				}
+				history.Nodes[int(binary.BigEndian.Uint64(k))] = series
+				return nil
+			}); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return nil
+	}))
+}
+
+func (s *BoltStorage) Save(content *Content) error {
+	if err := s.open(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.db.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{boltUsersBucket, boltNodesBucket}
+		if content.History != nil {
+			names = append(names, boltUsersHistoryBucket, boltNodesHistoryBucket)
+		}
+		for _, name := range names {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return errors.WithStack(err)
+				}
+			}
+		}
+		return s.commit(tx, content, &Changes{Meta: true, Users: content.Users, Nodes: content.Nodes})
+	}))
+}
+
+func (s *BoltStorage) Commit(content *Content, changes *Changes) error {
+	if err := s.open(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(s.db.Update(func(tx *bolt.Tx) error {
+		return s.commit(tx, content, changes)
+	}))
+}
+
+func (s *BoltStorage) commit(tx *bolt.Tx, content *Content, changes *Changes) error {
+	meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	users, err := tx.CreateBucketIfNotExists(boltUsersBucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nodes, err := tx.CreateBucketIfNotExists(boltNodesBucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if changes.Meta {
+		m := *content
+		m.Users, m.Nodes, m.History = nil, nil, nil
+		if m.Settings, err = s.sealer.sealSettings(content.Settings); err != nil {
+			return errors.WithStack(err)
+		}
+		data, err := json.Marshal(&m)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = meta.Put(boltMetaKey, data); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, u := range changes.Users {
+		sealed, err := s.sealer.sealUser(u)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = boltPut(users, u.Id, sealed); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, id := range changes.DeletedUsers {
+		if err = users.Delete(boltKey(id)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, n := range changes.Nodes {
+		sealed, err := s.sealer.sealNode(n)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = boltPut(nodes, n.Id, sealed); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, id := range changes.DeletedNodes {
+		if err = nodes.Delete(boltKey(id)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if content.History == nil {
+		return nil
+	}
+	return errors.WithStack(s.commitHistory(tx, content.History, changes))
+}
+
+// commitHistory persists the history of the changes: the total one with the meta, and the others with their rows.
+func (s *BoltStorage) commitHistory(tx *bolt.Tx, history *History, changes *Changes) error {
+	users, err := tx.CreateBucketIfNotExists(boltUsersHistoryBucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nodes, err := tx.CreateBucketIfNotExists(boltNodesHistoryBucket)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if changes.Meta {
+		data, err := json.Marshal(history.Total)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = tx.Bucket(boltMetaBucket).Put(boltTotalHistoryKey, data); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, u := range changes.Users {
+		if err = boltPutOrDelete(users, u.Id, history.user(u.Id)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, id := range changes.DeletedUsers {
+		if err = users.Delete(boltKey(id)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, n := range changes.Nodes {
+		if err = boltPutOrDelete(nodes, n.Id, history.Nodes[n.Id]); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, id := range changes.DeletedNodes {
+		if err = nodes.Delete(boltKey(id)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) Discard() (string, error) {
+	if err := s.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	path := fmt.Sprintf("%s.broken-%d", s.path, time.Now().Unix())
+	return path, errors.WithStack(os.Rename(s.path, path))
+}
+
+func (s *BoltStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return errors.WithStack(err)
+}
+
+// boltKey encodes ids in big-endian, so rows are iterated in the id order.
+func boltKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func boltPut(b *bolt.Bucket, id int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return b.Put(boltKey(id), data)
+}
+
+// boltPutOrDelete puts the value, or deletes the row for nil values.
+func boltPutOrDelete[T any](b *bolt.Bucket, id int, v *T) error {
+	if v == nil {
+		return b.Delete(boltKey(id))
+	}
+	return boltPut(b, id, v)
+}
+
+func newBoltStorage(path string, sealer *Sealer) *BoltStorage {
+	return &BoltStorage{path: path, sealer: sealer}
+}
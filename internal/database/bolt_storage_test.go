@@ -0,0 +1,134 @@
+package database
+
+import (
+	"github.com/miladrahimi/p-manager/internal/config"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBoltStorage(t *testing.T) *BoltStorage {
+	t.Helper()
+
+	env := config.NewEnv(t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(env.DatabaseBoltPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := GenerateKey()
+	sealer, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewStorage(DriverBolt, env, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = storage.Close()
+	})
+	return storage.(*BoltStorage)
+}
+
+func TestBoltStorageCommit(t *testing.T) {
+	d := New(nil, nil)
+	content := newContent()
+	content.Users = []*User{newTestUser(d, 1), newTestUser(d, 2), newTestUser(d, 3)}
+	content.Nodes = []*Node{{Id: 1, Host: "127.0.0.1", HttpToken: "token"}, {Id: 2, Host: "127.0.0.2"}}
+
+	tests := []struct {
+		name    string
+		changes func(c *Content) *Changes
+		users   []string
+		nodes   int
+		admin   string
+	}{
+		{
+			name:    "nothing",
+			changes: func(_ *Content) *Changes { return &Changes{} },
+			users:   []string{"user", "user", "user"},
+			nodes:   2,
+			admin:   "password",
+		},
+		{
+			name: "touched rows only",
+			changes: func(c *Content) *Changes {
+				c.Users[0].Name, c.Users[1].Name = "committed", "not committed"
+				c.Settings.AdminPassword = "not committed"
+				return &Changes{Users: c.Users[:1]}
+			},
+			users: []string{"committed", "user", "user"},
+			nodes: 2,
+			admin: "password",
+		},
+		{
+			name: "meta and deleted rows",
+			changes: func(c *Content) *Changes {
+				c.Settings.AdminPassword = "secret"
+				return &Changes{Meta: true, DeletedUsers: []int{2}, DeletedNodes: []int{1}}
+			},
+			users: []string{"user", "user"},
+			nodes: 1,
+			admin: "secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestBoltStorage(t)
+			if err := s.Save(content); err != nil {
+				t.Fatal(err)
+			}
+
+			c := newContent()
+			if err := s.Load(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Commit(c, tt.changes(c)); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := newContent()
+			if err := s.Load(loaded); err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded.Users) != len(tt.users) {
+				t.Fatalf("loaded %d users, want %d", len(loaded.Users), len(tt.users))
+			}
+			for i, name := range tt.users {
+				if loaded.Users[i].Name != name {
+					t.Errorf("user #%d name = %q, want %q", loaded.Users[i].Id, loaded.Users[i].Name, name)
+				}
+			}
+			if len(loaded.Nodes) != tt.nodes {
+				t.Errorf("loaded %d nodes, want %d", len(loaded.Nodes), tt.nodes)
+			}
+			if loaded.Settings.AdminPassword != tt.admin {
+				t.Errorf("admin password = %q, want %q", loaded.Settings.AdminPassword, tt.admin)
+			}
+			if loaded.Users[0].ShadowsocksPassword != content.Users[0].ShadowsocksPassword {
+				t.Errorf("the user password is not opened")
+			}
+		})
+	}
+}
+
+func TestBoltStorageLoadMissingBucket(t *testing.T) {
+	for _, bucket := range [][]byte{boltMetaBucket, boltUsersBucket, boltNodesBucket} {
+		t.Run(string(bucket), func(t *testing.T) {
+			s := newTestBoltStorage(t)
+			if err := s.Save(newContent()); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.db.Update(func(tx *bolt.Tx) error {
+				return tx.DeleteBucket(bucket)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Load(newContent()); err == nil {
+				t.Errorf("Load() error = nil, want an error for the missing bucket")
+			}
+		})
+	}
+}
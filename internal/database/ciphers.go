@@ -0,0 +1,103 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Shadowsocks 2022 methods supported by multi-user inbounds
+const (
+	Shadowsocks2022Aes128 = "2022-blake3-aes-128-gcm"
+	Shadowsocks2022Aes256 = "2022-blake3-aes-256-gcm"
+)
+
+// Is2022 reports whether the Shadowsocks method is a 2022 one, which takes base64 keys (PSKs) as passwords.
+func Is2022(method string) bool {
+	return strings.HasPrefix(method, "2022-")
+}
+
+// keySize returns the size of the keys of the 2022 method.
+func keySize(method string) int {
+	if method == Shadowsocks2022Aes128 {
+		return 16
+	}
+	return 32
+}
+
+// ValidPassword reports whether the password can be used with the method; 2022 methods take keys of their sizes only.
+func ValidPassword(method, password string) bool {
+	if !Is2022(method) {
+		return true
+	}
+	key, err := base64.StdEncoding.DecodeString(password)
+	return err == nil && len(key) == keySize(method)
+}
+
+// InboundMethod returns the Shadowsocks method of the inbound of the protocol.
+// Inbounds without 2022 methods take users of the other methods, each with its own method.
+func (d *Database) InboundMethod(protocol string) string {
+	switch protocol {
+	case ProtocolReverse:
+		return d.Content.Settings.SsReverseMethod
+	case ProtocolRelay:
+		return d.Content.Settings.SsRelayMethod
+	case ProtocolDirect:
+		return d.Content.Settings.SsDirectMethod
+	default:
+		return ""
+	}
+}
+
+// Joins reports whether the user can connect through the inbound of the protocol:
+// the user must be allowed to use the protocol and have a method the inbound supports.
+func (d *Database) Joins(u *User, protocol string) bool {
+	if !u.Allows(protocol) {
+		return false
+	}
+	if method := d.InboundMethod(protocol); Is2022(method) {
+		return u.ShadowsocksMethod == method
+	}
+	return !Is2022(u.ShadowsocksMethod)
+}
+
+// ServerKey returns the server key (PSK) of the inbounds with the given 2022 method.
+func (d *Database) ServerKey(method string) string {
+	key, err := base64.StdEncoding.DecodeString(d.Content.Settings.SsServerKey)
+	if err != nil || len(key) < keySize(method) {
+		return d.Content.Settings.SsServerKey
+	}
+	return base64.StdEncoding.EncodeToString(key[:keySize(method)])
+}
+
+// ShadowsocksLink returns the SIP002 link of the user for the inbound of the protocol on the given port,
+// with the password of the credential, or the main password for no credential.
+// Links of 2022 methods hold the server and user keys percent-encoded, and the others hold base64 user infos.
+func (d *Database) ShadowsocksLink(u *User, c *Credential, protocol string, port int) string {
+	password, tag := u.ShadowsocksPassword, protocol
+	if c != nil {
+		password, tag = c.Password, protocol+"-"+url.PathEscape(c.Name)
+	}
+
+	var info string
+	if Is2022(u.ShadowsocksMethod) {
+		info = u.ShadowsocksMethod + ":" + url.QueryEscape(d.ServerKey(u.ShadowsocksMethod)+":"+password)
+	} else {
+		info = base64.StdEncoding.EncodeToString([]byte(u.ShadowsocksMethod + ":" + password))
+	}
+	return fmt.Sprintf("ss://%s@%s:%d#%s", info, d.Content.Settings.Host, port, tag)
+}
+
+// generateKey generates a random base64 key (PSK) for the 2022 method.
+func generateKey(method string) string {
+	key := make([]byte, keySize(method))
+	_, _ = rand.Read(key) // It never returns an error.
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// generateServerKey generates the server key, long enough for the keys of every 2022 method.
+func generateServerKey() string {
+	return generateKey(Shadowsocks2022Aes256)
+}
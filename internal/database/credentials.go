@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"github.com/miladrahimi/p-manager/internal/utils"
+)
+
+// MaxCredentials is the maximum number of credentials of a user.
+const MaxCredentials = 16
+
+// Credential is a named password of a user for one of its devices, connecting as a separate client.
+// The main password of the user is not a credential.
+type Credential struct {
+	Id         int     `json:"id"`
+	Name       string  `json:"name" validate:"required,min=1,max=32"`
+	Password   string  `json:"password" validate:"required,min=1,max=64"`
+	Usage      float64 `json:"usage" validate:"min=0"`
+	UsageBytes int64   `json:"usage_bytes" validate:"min=0"`
+	CreatedAt  int64   `json:"created_at"`
+}
+
+// AddTraffic adds the bytes passed in both directions to the usage of the credential.
+func (c *Credential) AddTraffic(bytes int64) {
+	c.UsageBytes += bytes
+	c.Usage = utils.RoundFloat(float64(c.UsageBytes)/1000/1000/1000, 2)
+}
+
+// FindCredential returns the credential of the user with the given id, or nil if there is none.
+func (u *User) FindCredential(id int) *Credential {
+	for _, c := range u.Credentials {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// CloneCredentials returns copies of the credentials of the user, to change them without touching the originals.
+func (u *User) CloneCredentials() []*Credential {
+	if u.Credentials == nil {
+		return nil
+	}
+	credentials := make([]*Credential, len(u.Credentials))
+	for i, c := range u.Credentials {
+		credential := *c
+		credentials[i] = &credential
+	}
+	return credentials
+}
+
+// GenerateCredentialId returns a new id for a credential of the user and counts it.
+// The ids of the deleted credentials are never reused, as the client emails and the stats are named after them.
+func (u *User) GenerateCredentialId() int {
+	id := u.LastCredentialId
+	for _, c := range u.Credentials {
+		id = max(id, c.Id)
+	}
+	u.LastCredentialId = id + 1
+	return u.LastCredentialId
+}
+
+// ClientEmail returns the email of the Xray client of the user, "<user>" for the main password
+// and "<user>.<credential>" for the credentials (nil for none), which the stats are named after.
+func ClientEmail(u *User, c *Credential) string {
+	if c == nil {
+		return fmt.Sprintf("%d", u.Id)
+	}
+	return fmt.Sprintf("%d.%d", u.Id, c.Id)
+}
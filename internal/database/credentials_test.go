@@ -0,0 +1,42 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestGenerateCredentialId(t *testing.T) {
+	tests := []struct {
+		name        string
+		last        int
+		credentials []int
+		want        int
+	}{
+		{"first credential", 0, nil, 1},
+		{"after the credentials", 2, []int{1, 2}, 3},
+		{"after the deleted last credential", 3, []int{1, 2}, 4},
+		{"after every credential deleted", 3, nil, 4},
+		{"user without the counter", 0, []int{1, 5}, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &User{Id: 7, LastCredentialId: tt.last}
+			for _, id := range tt.credentials {
+				u.Credentials = append(u.Credentials, &Credential{Id: id})
+			}
+
+			id := u.GenerateCredentialId()
+			if id != tt.want {
+				t.Errorf("id = %d, want %d", id, tt.want)
+			}
+			if u.LastCredentialId != tt.want {
+				t.Errorf("last credential id = %d, want %d", u.LastCredentialId, tt.want)
+			}
+			for _, c := range u.Credentials {
+				if ClientEmail(u, c) == ClientEmail(u, &Credential{Id: id}) {
+					t.Errorf("the client email %s is reused", ClientEmail(u, c))
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package database
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
@@ -11,98 +10,281 @@ import (
 	"github.com/miladrahimi/p-node/pkg/logger"
 	"go.uber.org/zap"
 	"os"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 )
 
+// ErrNewerSchema is returned when the database was written by a newer version of the application.
+var ErrNewerSchema = errors.New("database schema is newer than the application supports")
+
 type Content struct {
-	Settings *Settings `json:"settings"`
-	Stats    *Stats    `json:"stats"`
-	Users    []*User   `json:"users"`
-	Nodes    []*Node   `json:"nodes"`
+	Version    int       `json:"version"`
+	Settings   *Settings `json:"settings"`
+	Stats      *Stats    `json:"stats"`
+	Users      []*User   `json:"users"`
+	Nodes      []*Node   `json:"nodes"`
+	Plans      []*Plan   `json:"plans"`
+	LastPlanId int       `json:"last_plan_id"`
+	History    *History  `json:"history,omitempty"`
 }
 
 type Database struct {
 	Content *Content
-	Locker  *sync.Mutex
+	Locker  *sync.RWMutex
 	l       *logger.Logger
 	c       *config.Config
+	storage Storage
+	sealer  *Sealer
 }
 
-func (d *Database) Init() error {
+func (d *Database) Init() (err error) {
 	d.Locker.Lock()
 	defer d.Locker.Unlock()
 
-	if utils.FileExist(d.c.Env.DatabasePath) {
-		err := d.Load()
+	if d.sealer, err = LoadSealer(d.c.Env); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if d.storage, err = NewStorage(d.c.Database.Driver, d.c.Env, d.sealer); err != nil {
 		return errors.WithStack(err)
 	}
 
-	err := d.Save()
+	if d.storage.Exists() {
+		err = d.Load()
+		if err == nil || errors.Is(err, ErrNewerSchema) {
+			return errors.WithStack(err)
+		}
+		d.l.Error("database: cannot load, recovering from backups...", zap.Error(errors.WithStack(err)))
+		return errors.WithStack(d.recover())
+	}
+
+	err = d.Save()
 	return errors.WithStack(err)
 }
 
 func (d *Database) Load() error {
-	content, err := os.ReadFile(d.c.Env.DatabasePath)
-	if err != nil {
+	content := newContent()
+	if err := d.storage.Load(content); err != nil {
+		return errors.WithStack(err)
+	}
+	d.loadHistory(content)
+	if err := validator.New().Struct(content); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := checkVersion(content); err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = json.Unmarshal(content, d.Content)
-	if err != nil {
+	if content.Version == SchemaVersion() {
+		d.Content = content
+		return nil
+	}
+
+	if err := d.backupBeforeMigration(content); err != nil {
 		return errors.WithStack(err)
 	}
 
-	d.modify()
+	d.migrate(content)
+	d.Content = content
 
-	err = validator.New().Struct(d)
-	return errors.WithStack(err)
+	return errors.WithStack(d.Save())
 }
 
-func (d *Database) modify() {
-	for _, user := range d.Content.Users {
-		if user.UsageResetAt == 0 {
-			user.UsageResetAt = time.Now().UnixMilli()
-		}
+// loadHistory loads the stored history into the content.
+// The history is stored apart, so a broken one is logged and left out rather than failing the whole database.
+func (d *Database) loadHistory(content *Content) {
+	if content.History == nil {
+		content.History = newHistory()
+	}
+	if err := d.storage.LoadHistory(content.History); err != nil {
+		d.l.Error("database: cannot load history", zap.Error(errors.WithStack(err)))
 	}
 }
 
-func (d *Database) Save() error {
-	content, err := json.Marshal(d.Content)
+// read loads and validates the content of the given backup file.
+func (d *Database) read(path string) (*Content, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
-
-	err = os.WriteFile(d.c.Env.DatabasePath, content, 0755)
-	return errors.WithStack(err)
+	return d.decodeBackup(data)
 }
 
-func (d *Database) Close() {
-	content, err := json.Marshal(d.Content)
+// decodeBackup decodes and validates the content of backup data (plain or compressed).
+func (d *Database) decodeBackup(data []byte) (*Content, error) {
+	data, err := decompress(data)
 	if err != nil {
-		d.l.Error("database: close: cannot marshal data", zap.Error(errors.WithStack(err)))
+		return nil, errors.WithStack(err)
 	}
 
-	if err = os.WriteFile(d.c.Env.DatabasePath, content, 0755); err != nil {
-		d.l.Error("database: close: cannot save file", zap.Error(errors.WithStack(err)))
+	// The history stays nil for the backups without it, so the callers can tell them apart.
+	content := newContent()
+	content.History = nil
+	if err = decodeFile(data, content, d.sealer); err != nil {
+		return nil, errors.WithStack(err)
 	}
+
+	if err = validator.New().Struct(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = checkVersion(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return content, nil
 }
 
-func (d *Database) Backup() {
-	d.Locker.Lock()
-	defer d.Locker.Unlock()
+// checkVersion refuses the content written by a newer version of the application.
+func checkVersion(content *Content) error {
+	if content.Version > SchemaVersion() {
+		return errors.Wrapf(ErrNewerSchema, "found version %d, supported version %d", content.Version, SchemaVersion())
+	}
+	return nil
+}
+
+// backupBeforeMigration keeps the content as it was before running the migrations.
+func (d *Database) backupBeforeMigration(content *Content) error {
+	name := fmt.Sprintf("v%d-%s", content.Version, time.Now().Format("20060102-150405"))
+	path, err := d.writeBackup(name, content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	d.l.Info("database: backed up before migration", zap.String("file", path))
+	return nil
+}
 
-	content, err := json.Marshal(d.Content)
+// migrate runs the pending migrations on the content.
+func (d *Database) migrate(content *Content) {
+	for i := content.Version; i < SchemaVersion(); i++ {
+		d.l.Info("database: running migration...", zap.Int("version", i+1), zap.String("name", migrations[i].Name))
+		migrations[i].Up(content)
+		content.Version = i + 1
+	}
+}
+
+// recover replaces a broken storage with the newest valid backup.
+// The scheduled backups are tried first, then the ones taken before migrations and restores, and then the legacy ones.
+// Uploaded backups are never restored automatically, as they may come from anywhere.
+// The broken storage is kept next to the database for further investigation.
+func (d *Database) recover() error {
+	backups, err := d.recoveryBackups()
 	if err != nil {
-		d.l.Error("database: cannot marshal data", zap.Error(errors.WithStack(err)))
+		return errors.WithStack(err)
 	}
 
-	path := strings.ToLower(fmt.Sprintf(d.c.Env.DatabaseBackupPath, time.Now().Format("Mon-15")))
-	if err = os.WriteFile(path, content, 0755); err != nil {
-		d.l.Fatal(
-			"database: cannot save backup file", zap.String("file", path), zap.Error(errors.WithStack(err)),
+	// The history is stored apart, so it may have survived for the backups without it.
+	history := newHistory()
+	if err = d.storage.LoadHistory(history); err != nil {
+		d.l.Warn("database: cannot load history, recovering without it", zap.Error(errors.WithStack(err)))
+	}
+
+	for _, backup := range backups {
+		content, err := d.read(backup)
+		if err != nil {
+			d.l.Warn("database: invalid backup ignored", zap.String("file", backup), zap.Error(err))
+			continue
+		}
+
+		broken, err := d.storage.Discard()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if content.History == nil {
+			content.History = history
+		}
+		d.migrate(content)
+		d.Content = content
+		if err = d.Save(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		d.l.Warn(
+			"database: recovered from backup",
+			zap.String("backup", backup),
+			zap.String("broken", broken),
+			zap.Int("users", len(content.Users)),
+			zap.Int("nodes", len(content.Nodes)),
 		)
+
+		return nil
+	}
+
+	return errors.Errorf("no valid backup found among %d files", len(backups))
+}
+
+// recoveryBackups returns the backups recover may use, in the order to try them.
+func (d *Database) recoveryBackups() ([]string, error) {
+	paths, err := d.backups()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	ranks := map[string]int{backupScheduled: 1, backupMigration: 2, backupRestore: 3, backupLegacy: 4}
+
+	var backups []string
+	for _, path := range paths {
+		if ranks[d.backupKind(path)] > 0 {
+			backups = append(backups, path)
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return ranks[d.backupKind(backups[i])] < ranks[d.backupKind(backups[j])]
+	})
+
+	return backups, nil
+}
+
+// Save persists the whole content.
+func (d *Database) Save() error {
+	return errors.WithStack(d.storage.Save(d.Content))
+}
+
+// Commit persists only the given changes, so storages with rows skip the untouched ones.
+func (d *Database) Commit(changes *Changes) error {
+	return errors.WithStack(d.storage.Commit(d.Content, changes))
+}
+
+// SaveMeta persists everything but users and nodes, like settings and stats.
+func (d *Database) SaveMeta() error {
+	return d.Commit(&Changes{Meta: true})
+}
+
+func (d *Database) SaveUsers(users ...*User) error {
+	return d.Commit(&Changes{Users: users})
+}
+
+// DeleteUsers removes the users and their usage history from the storage.
+func (d *Database) DeleteUsers(ids ...int) error {
+	for _, id := range ids {
+		d.Content.History.deleteUser(id)
+	}
+	return d.Commit(&Changes{DeletedUsers: ids})
+}
+
+func (d *Database) SaveNodes(nodes ...*Node) error {
+	return d.Commit(&Changes{Nodes: nodes})
+}
+
+// DeleteNodes removes the nodes and their usage history from the storage.
+func (d *Database) DeleteNodes(ids ...int) error {
+	for _, id := range ids {
+		delete(d.Content.History.Nodes, id)
+	}
+	return d.Commit(&Changes{DeletedNodes: ids})
+}
+
+func (d *Database) Close() {
+	if d.storage == nil {
+		return
+	}
+	if err := d.Save(); err != nil {
+		d.l.Error("database: close: cannot save content", zap.Error(errors.WithStack(err)))
+	}
+	if err := d.storage.Close(); err != nil {
+		d.l.Error("database: close: cannot close storage", zap.Error(errors.WithStack(err)))
 	}
 }
 
@@ -116,6 +298,17 @@ func (d *Database) CountActiveUsers() int {
 	return activeUsersCount
 }
 
+// CountOnlineUsers returns the number of the users with traffic in the last stats sync.
+func (d *Database) CountOnlineUsers() int {
+	count := 0
+	for _, u := range d.Content.Users {
+		if u.Online {
+			count++
+		}
+	}
+	return count
+}
+
 func (d *Database) GenerateUserId() int {
 	if len(d.Content.Users) > 0 {
 		return d.Content.Users[len(d.Content.Users)-1].Id + 1
@@ -128,22 +321,48 @@ func (d *Database) GenerateUserIdentity() string {
 	return utils.UUID()
 }
 
-func (d *Database) GenerateUserPassword() string {
+// GenerateUserPassword generates a unique password for the Shadowsocks method, a key (PSK) for 2022 methods.
+func (d *Database) GenerateUserPassword(method string) string {
 	for {
 		r := random.String(16)
-		isUnique := true
-		for _, user := range d.Content.Users {
-			if user.ShadowsocksPassword == r {
-				isUnique = false
-				break
-			}
+		if Is2022(method) {
+			r = generateKey(method)
 		}
-		if isUnique {
+		if !d.IsPasswordTaken(r, nil) {
 			return r
 		}
 	}
 }
 
+// IsPasswordTaken reports whether the password belongs to another user (than the given one), a credential,
+// or a previous password in its grace period.
+// The given user can take its own previous password back, but not the password of its own credentials,
+// as Xray tells the clients apart by their passwords.
+func (d *Database) IsPasswordTaken(password string, except *User) bool {
+	for _, user := range d.Content.Users {
+		if user != except && (user.ShadowsocksPassword == password || user.PreviousPassword == password) {
+			return true
+		}
+		for _, c := range user.Credentials {
+			if c.Password == password {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsIdentityTaken reports whether the identity belongs to another user (than the given one),
+// or is a previous identity in its grace period. The given user can take its own previous identity back.
+func (d *Database) IsIdentityTaken(identity string, except *User) bool {
+	for _, user := range d.Content.Users {
+		if user != except && (user.Identity == identity || user.PreviousIdentity == identity) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Database) GenerateNodeId() int {
 	if len(d.Content.Nodes) > 0 {
 		return d.Content.Nodes[len(d.Content.Nodes)-1].Id + 1
@@ -152,25 +371,38 @@ func (d *Database) GenerateNodeId() int {
 	}
 }
 
+func newContent() *Content {
+	return &Content{
+		Settings: &Settings{
+			AdminPassword: "password",
+			Host:          "127.0.0.1",
+			SsReversePort: 0,
+			SsRelayPort:   0,
+			TrafficRatio:  1,
+			QuotaPolicy:   QuotaPolicyTotal,
+			QuotaWarnings: []int{80, 95},
+		},
+		Stats: &Stats{
+			TotalUsage:        0,
+			TotalUsageResetAt: time.Now().UnixMilli(),
+		},
+		Users:   []*User{},
+		Nodes:   []*Node{},
+		Plans:   []*Plan{},
+		History: newHistory(),
+	}
+}
+
 func New(l *logger.Logger, c *config.Config) *Database {
+	// The content loaded from the storage replaces this one, and the migrations give the older ones a server key.
+	content := newContent()
+	content.Version = SchemaVersion()
+	content.Settings.SsServerKey = generateServerKey()
+
 	return &Database{
-		Locker: &sync.Mutex{},
-		l:      l,
-		c:      c,
-		Content: &Content{
-			Settings: &Settings{
-				AdminPassword: "password",
-				Host:          "127.0.0.1",
-				SsReversePort: 0,
-				SsRelayPort:   0,
-				TrafficRatio:  1,
-			},
-			Stats: &Stats{
-				TotalUsage:        0,
-				TotalUsageResetAt: time.Now().UnixMilli(),
-			},
-			Users: []*User{},
-			Nodes: []*Node{},
-		},
+		Locker:  &sync.RWMutex{},
+		l:       l,
+		c:       c,
+		Content: content,
 	}
 }
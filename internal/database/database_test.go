@@ -0,0 +1,134 @@
+package database
+
+import (
+	"cmp"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDatabase returns a database with the default config in a temporary directory, not initialized yet.
+func newTestDatabase(t *testing.T, driver string) *Database {
+	t.Helper()
+
+	t.Setenv(config.DatabaseKeyEnv, "")
+
+	env := config.NewEnv(t.TempDir())
+	env.DefaultConfigPath = filepath.Join("..", "..", "configs", "main.defaults.json")
+	for _, directory := range []string{"storage/app", "storage/database", "storage/logs"} {
+		if err := os.MkdirAll(filepath.Join(env.AppDirectory, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := config.New(env)
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	c.Database.Driver = driver
+
+	l := logger.New(c.Logger.Level, c.Logger.Format, make(chan struct{}))
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(l, c)
+}
+
+// newTestUser returns a valid user with the given id.
+func newTestUser(d *Database, id int) *User {
+	return &User{
+		Id:                  id,
+		Identity:            d.GenerateUserIdentity(),
+		Name:                "user",
+		Enabled:             true,
+		ShadowsocksMethod:   config.ShadowsocksMethod,
+		ShadowsocksPassword: d.GenerateUserPassword(config.ShadowsocksMethod),
+	}
+}
+
+// recoverTest is a case of recovering a broken primary storage from the backups the case writes.
+type recoverTest struct {
+	name    string
+	driver  string
+	primary string
+	write   func(t *testing.T, d *Database)
+	users   int
+	wantErr bool
+}
+
+// testRecover runs the cases, each with the primary storage replaced by the broken one ("{corrupt" by default).
+func testRecover(t *testing.T, tests []recoverTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t, tt.driver)
+			if err := d.Init(); err != nil {
+				t.Fatal(err)
+			}
+			tt.write(t, d)
+			d.Close()
+
+			path := d.c.Env.DatabasePath
+			if tt.driver == DriverBolt {
+				path = d.c.Env.DatabaseBoltPath
+			}
+			primary := cmp.Or(tt.primary, "{corrupt")
+			if err := os.WriteFile(path, []byte(primary), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			d = New(d.l, d.c)
+			err := d.Init()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Init() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer d.Close()
+
+			if len(d.Content.Users) != tt.users {
+				t.Errorf("recovered %d users, want %d", len(d.Content.Users), tt.users)
+			}
+			if err = d.Load(); err != nil {
+				t.Errorf("the recovered database cannot be loaded: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	testRecover(t, []recoverTest{
+		{
+			name:   "newest valid backup",
+			driver: DriverJson,
+			write: func(t *testing.T, d *Database) {
+				writeTestBackup(t, d, "20200101-00", 1, 3*time.Hour)
+				writeTestBackup(t, d, "20200101-01", 2, 2*time.Hour)
+				writeTestFile(t, d, "20200101-02", "{corrupt", time.Hour)
+			},
+			users: 2,
+		},
+		{
+			name:    "primary with a checksum mismatch",
+			driver:  DriverJson,
+			primary: `{"checksum":"0","content":{"version":1}}`,
+			write: func(t *testing.T, d *Database) {
+				writeTestBackup(t, d, "20200101-00", 1, 2*time.Hour)
+				writeTestFile(t, d, "20200101-01", `{"checksum":"0","content":{}}`, time.Hour)
+			},
+			users: 1,
+		},
+		{
+			name:   "backups with checksum mismatches only",
+			driver: DriverJson,
+			write: func(t *testing.T, d *Database) {
+				writeTestFile(t, d, "20200101-00", `{"checksum":"0","content":{}}`, time.Hour)
+			},
+			wantErr: true,
+		},
+	})
+}
@@ -0,0 +1,66 @@
+package database
+
+// Event types
+const (
+	EventQuotaWarning  = "quota_warning"
+	EventQuotaExceeded = "quota_exceeded"
+	EventExpired       = "expired"
+	EventIpsExceeded   = "ips_exceeded"
+	EventRotated       = "rotated"
+)
+
+// Event is something that happened to a user, like reaching a quota warning threshold.
+type Event struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Time    int64  `json:"time"`
+}
+
+// RecordUserEvent adds the event to the events of the user and drops the ones beyond the retention.
+func (d *Database) RecordUserEvent(id int, e *Event) {
+	events := append(d.Content.History.Events[id], e)
+	if count := d.c.History.Events; len(events) > count {
+		events = events[len(events)-count:]
+	}
+	if len(events) > 0 {
+		d.Content.History.Events[id] = events
+	} else {
+		delete(d.Content.History.Events, id)
+	}
+}
+
+// UserEvents returns the events of the user, the newest first.
+func (d *Database) UserEvents(id int) []*Event {
+	events := d.Content.History.Events[id]
+	result := make([]*Event, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		result = append(result, events[i])
+	}
+	return result
+}
+
+// QuotaWarning returns the highest warning threshold (percent) of the settings the user has reached
+// and not been warned about in the current period, or zero for none.
+func (d *Database) QuotaWarning(u *User) int {
+	if reached := d.reachedQuotaWarning(u); reached > u.QuotaWarning {
+		return reached
+	}
+	return 0
+}
+
+// reachedQuotaWarning returns the highest warning threshold (percent) of the settings the usage of the user reaches,
+// whether the user has been warned about it or not, or zero for none.
+func (d *Database) reachedQuotaWarning(u *User) int {
+	if u.Quota <= 0 {
+		return 0
+	}
+	percent := u.QuotaUsage(d.Content.Settings.QuotaPolicy) / u.Quota * 100
+
+	warning := 0
+	for _, threshold := range d.Content.Settings.QuotaWarnings {
+		if percent >= float64(threshold) && threshold > warning {
+			warning = threshold
+		}
+	}
+	return warning
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/cockroachdb/errors"
+)
+
+// File is the on-disk layout of the database and its backups.
+// Checksum is the hex-encoded SHA-256 of the raw Content bytes.
+type File struct {
+	Checksum string          `json:"checksum"`
+	Content  json.RawMessage `json:"content"`
+}
+
+// encodeFile seals and marshals the content and wraps it with its checksum.
+func encodeFile(content *Content, sealer *Sealer) ([]byte, error) {
+	sealed, err := sealer.SealContent(content)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sum := sha256.Sum256(c)
+	f, err := json.Marshal(&File{Checksum: hex.EncodeToString(sum[:]), Content: c})
+	return f, errors.WithStack(err)
+}
+
+// decodeFile verifies the checksum, unmarshals the content and opens its sealed fields.
+// Files written before checksums were introduced are plain content and are accepted as they are.
+func decodeFile(data []byte, content *Content, sealer *Sealer) error {
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if f.Content == nil {
+		if err := json.Unmarshal(data, content); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(sealer.OpenContent(content))
+	}
+
+	sum := sha256.Sum256(f.Content)
+	if hex.EncodeToString(sum[:]) != f.Checksum {
+		return errors.Errorf("checksum mismatch, expected %s", f.Checksum)
+	}
+
+	if err := json.Unmarshal(f.Content, content); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(sealer.OpenContent(content))
+}
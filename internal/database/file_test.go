@@ -0,0 +1,55 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFile(t *testing.T) {
+	key, _ := GenerateKey()
+	sealer, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := newContent()
+	content.Version = SchemaVersion()
+	content.Settings.AdminPassword = "secret-password"
+	encoded, err := encodeFile(content, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"encoded", string(encoded), false},
+		{"plain content of older versions", string(plain), false},
+		{"checksum mismatch", strings.Replace(string(encoded), `"checksum":"`, `"checksum":"0`, 1), true},
+		{"truncated", string(encoded[:len(encoded)/2]), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded := newContent()
+			err := decodeFile([]byte(tt.data), decoded, sealer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeFile() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && decoded.Settings.AdminPassword != "secret-password" {
+				t.Errorf("admin password = %q, want the opened one", decoded.Settings.AdminPassword)
+			}
+		})
+	}
+
+	if strings.Contains(string(encoded), "secret-password") {
+		t.Errorf("the encoded file has the plain admin password")
+	}
+}
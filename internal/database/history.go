@@ -0,0 +1,220 @@
+package database
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"time"
+)
+
+const (
+	GranularityFiveMinutes = "five_minutes"
+	GranularityHourly      = "hourly"
+	GranularityDaily       = "daily"
+)
+
+// Point is the traffic of the period starting at Time (Unix milliseconds).
+type Point struct {
+	Time  int64 `json:"time"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Series is the usage history of a record, kept at several granularities.
+// Each granularity is an independent series fed with the same raw traffic, not rolled up from the finer ones,
+// so every granularity is complete for its own retention, and finer ones may be disabled without losing the coarser ones.
+type Series struct {
+	FiveMinutes []*Point `json:"five_minutes,omitempty"`
+	Hourly      []*Point `json:"hourly,omitempty"`
+	Daily       []*Point `json:"daily,omitempty"`
+}
+
+// points returns the points of the given granularity, or nil for unknown ones.
+func (s *Series) points(granularity string) *[]*Point {
+	switch granularity {
+	case GranularityFiveMinutes:
+		return &s.FiveMinutes
+	case GranularityHourly:
+		return &s.Hourly
+	case GranularityDaily:
+		return &s.Daily
+	default:
+		return nil
+	}
+}
+
+// add adds the traffic to the points of every enabled granularity (each on its own)
+// and drops the points beyond the retention.
+func (s *Series) add(t time.Time, bytes int64, retention config.HistoryRetention) {
+	for granularity, count := range map[string]int{
+		GranularityFiveMinutes: retention.FiveMinutes,
+		GranularityHourly:      retention.Hourly,
+		GranularityDaily:       retention.Daily,
+	} {
+		points := s.points(granularity)
+		if count == 0 {
+			*points = nil
+			continue
+		}
+
+		start := periodStart(granularity, t)
+		if n := len(*points); n > 0 && (*points)[n-1].Time == start.UnixMilli() {
+			(*points)[n-1].Bytes += bytes
+		} else {
+			*points = append(*points, &Point{Time: start.UnixMilli(), Bytes: bytes})
+		}
+
+		from := start.Add(-time.Duration(count-1) * period(granularity)).UnixMilli()
+		for len(*points) > 0 && (*points)[0].Time < from {
+			*points = (*points)[1:]
+		}
+	}
+}
+
+// Query returns the points of the given granularity in the given time range (Unix milliseconds, zero for open ends).
+func (s *Series) Query(granularity string, from, to int64) ([]*Point, error) {
+	points := s.points(granularity)
+	if points == nil {
+		return nil, errors.Errorf("unknown granularity: %s", granularity)
+	}
+
+	result := []*Point{}
+	for _, p := range *points {
+		if (from == 0 || p.Time >= from) && (to == 0 || p.Time <= to) {
+			result = append(result, p)
+		}
+	}
+
+	return result, nil
+}
+
+// History holds the usage series of the users, the nodes, and the whole traffic,
+// the archived usages of the past reset periods of the users, the ledgers of their top-ups and adjustments,
+// and their events.
+// It is stored apart from the rest of the content, next to each user and node (see Changes),
+// and is left out of the backups unless configured.
+type History struct {
+	Users   map[int]*Series        `json:"users"`
+	Nodes   map[int]*Series        `json:"nodes"`
+	Total   *Series                `json:"total"`
+	Periods map[int][]*Period      `json:"periods"`
+	Ledger  map[int][]*LedgerEntry `json:"ledger"`
+	Events  map[int][]*Event       `json:"events"`
+}
+
+// UserHistory is the history of one user, as it is stored.
+type UserHistory struct {
+	Usage   *Series        `json:"usage,omitempty"`
+	Periods []*Period      `json:"periods,omitempty"`
+	Ledger  []*LedgerEntry `json:"ledger,omitempty"`
+	Events  []*Event       `json:"events,omitempty"`
+}
+
+// user returns the history of the user, or nil when it has none.
+func (h *History) user(id int) *UserHistory {
+	uh := &UserHistory{Usage: h.Users[id], Periods: h.Periods[id], Ledger: h.Ledger[id], Events: h.Events[id]}
+	if uh.Usage == nil && uh.Periods == nil && uh.Ledger == nil && uh.Events == nil {
+		return nil
+	}
+	return uh
+}
+
+// setUser replaces the history of the user.
+func (h *History) setUser(id int, uh *UserHistory) {
+	h.deleteUser(id)
+	if uh.Usage != nil {
+		h.Users[id] = uh.Usage
+	}
+	if uh.Periods != nil {
+		h.Periods[id] = uh.Periods
+	}
+	if uh.Ledger != nil {
+		h.Ledger[id] = uh.Ledger
+	}
+	if uh.Events != nil {
+		h.Events[id] = uh.Events
+	}
+}
+
+// deleteUser removes the history of the user.
+func (h *History) deleteUser(id int) {
+	delete(h.Users, id)
+	delete(h.Periods, id)
+	delete(h.Ledger, id)
+	delete(h.Events, id)
+}
+
+// RecordUserUsage adds the traffic of the user at the given time to its usage history.
+func (d *Database) RecordUserUsage(id int, t time.Time, bytes int64) {
+	seriesOf(d.Content.History.Users, id).add(t.In(d.Location()), bytes, d.c.History.Users)
+}
+
+// RecordNodeUsage adds the traffic of the node at the given time to its usage history.
+func (d *Database) RecordNodeUsage(id int, t time.Time, bytes int64) {
+	seriesOf(d.Content.History.Nodes, id).add(t.In(d.Location()), bytes, d.c.History.Nodes)
+}
+
+// RecordTotalUsage adds the traffic of all the inbounds at the given time to the total usage history.
+func (d *Database) RecordTotalUsage(t time.Time, bytes int64) {
+	d.Content.History.Total.add(t.In(d.Location()), bytes, d.c.History.Total)
+}
+
+// UserUsage returns the usage history of the user; users without any traffic have an empty one.
+func (d *Database) UserUsage(id int, granularity string, from, to int64) ([]*Point, error) {
+	return seriesOrEmpty(d.Content.History.Users, id).Query(granularity, from, to)
+}
+
+// NodeUsage returns the usage history of the node; nodes without any traffic have an empty one.
+func (d *Database) NodeUsage(id int, granularity string, from, to int64) ([]*Point, error) {
+	return seriesOrEmpty(d.Content.History.Nodes, id).Query(granularity, from, to)
+}
+
+// TotalUsage returns the usage history of all the inbounds.
+func (d *Database) TotalUsage(granularity string, from, to int64) ([]*Point, error) {
+	return d.Content.History.Total.Query(granularity, from, to)
+}
+
+func seriesOf(m map[int]*Series, id int) *Series {
+	series, found := m[id]
+	if !found {
+		series = &Series{}
+		m[id] = series
+	}
+	return series
+}
+
+func seriesOrEmpty(m map[int]*Series, id int) *Series {
+	if series, found := m[id]; found {
+		return series
+	}
+	return &Series{}
+}
+
+func period(granularity string) time.Duration {
+	switch granularity {
+	case GranularityFiveMinutes:
+		return 5 * time.Minute
+	case GranularityHourly:
+		return time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func periodStart(granularity string, t time.Time) time.Time {
+	switch granularity {
+	case GranularityFiveMinutes, GranularityHourly:
+		return t.Truncate(period(granularity))
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+func newHistory() *History {
+	return &History{
+		Users:   map[int]*Series{},
+		Nodes:   map[int]*Series{},
+		Total:   &Series{},
+		Periods: map[int][]*Period{},
+		Ledger:  map[int][]*LedgerEntry{},
+		Events:  map[int][]*Event{},
+	}
+}
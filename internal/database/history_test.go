@@ -0,0 +1,131 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryStorage(t *testing.T) {
+	for _, driver := range []string{DriverJson, DriverBolt} {
+		t.Run(driver, func(t *testing.T) {
+			d := newTestDatabase(t, driver)
+			if err := d.Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			u1, u2 := newTestUser(d, 1), newTestUser(d, 2)
+			d.Content.Users = append(d.Content.Users, u1, u2)
+			if err := d.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			d.RecordUserUsage(u1.Id, now, 100)
+			d.RecordUserUsage(u2.Id, now, 200)
+			d.RecordUserEvent(u1.Id, &Event{Type: EventQuotaWarning, Time: now.UnixMilli()})
+			d.RecordTotalUsage(now, 300)
+			// The second user is not committed, so its history is not stored in the embedded store.
+			if err := d.Commit(&Changes{Meta: true, Users: []*User{u1}}); err != nil {
+				t.Fatal(err)
+			}
+
+			path, err := d.writeBackup("20200101-00", d.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Closing the database saves the whole content, so only the storage is closed.
+			if err = d.storage.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			d = New(d.l, d.c)
+			if err = d.Init(); err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			if points, _ := d.UserUsage(u1.Id, GranularityHourly, 0, 0); len(points) != 1 || points[0].Bytes != 100 {
+				t.Errorf("committed user usage = %v, want one point of 100 bytes", points)
+			}
+			if points, _ := d.UserUsage(u2.Id, GranularityHourly, 0, 0); driver == DriverBolt && len(points) != 0 {
+				t.Errorf("uncommitted user usage = %v, want none", points)
+			}
+			if events := d.Content.History.Events[u1.Id]; len(events) != 1 {
+				t.Errorf("events = %v, want one", events)
+			}
+			if points, _ := d.TotalUsage(GranularityHourly, 0, 0); len(points) != 1 || points[0].Bytes != 300 {
+				t.Errorf("total usage = %v, want one point of 300 bytes", points)
+			}
+
+			content, err := d.read(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content.History != nil {
+				t.Errorf("the backup has the history")
+			}
+			if err = d.Restore(content); err != nil {
+				t.Fatal(err)
+			}
+			if points, _ := d.UserUsage(u1.Id, GranularityHourly, 0, 0); len(points) != 1 {
+				t.Errorf("restoring a backup without history dropped the current one")
+			}
+
+			if err = d.DeleteUsers(u1.Id); err != nil {
+				t.Fatal(err)
+			}
+			history := newHistory()
+			if err = d.storage.LoadHistory(history); err != nil {
+				t.Fatal(err)
+			}
+			if history.user(u1.Id) != nil {
+				t.Errorf("the history of the deleted user is kept")
+			}
+		})
+	}
+}
+
+func TestHistoryMigration(t *testing.T) {
+	d := newTestDatabase(t, DriverJson)
+
+	// Older versions kept the history in the content.
+	content := newContent()
+	content.Version = SchemaVersion() - 1
+	content.Users = []*User{newTestUser(d, 1)}
+	content.History.Users[1] = &Series{Daily: []*Point{{Time: 1, Bytes: 100}}}
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(d.c.Env.DatabasePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if points, _ := d.UserUsage(1, GranularityDaily, 0, 0); len(points) != 1 {
+		t.Errorf("the history in the content is lost")
+	}
+
+	data, err = os.ReadFile(d.c.Env.DatabasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"history"`) {
+		t.Errorf("the history is still in the content")
+	}
+
+	history := newHistory()
+	if err = d.storage.LoadHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if history.Users[1] == nil {
+		t.Errorf("the history is not stored apart")
+	}
+}
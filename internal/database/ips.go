@@ -0,0 +1,19 @@
+package database
+
+// IP limits define what happens to users connecting from more IPs than their limits.
+const (
+	IpLimitDisable = "disable"
+	IpLimitFlag    = "flag"
+)
+
+// IpLimit returns the IP limit of the user: disabling limits suspend users for a while, and flagging limits only flag them.
+// The limit of the settings applies when the user has none.
+func (d *Database) IpLimit(u *User) string {
+	if u.IpLimit != "" {
+		return u.IpLimit
+	}
+	if d.Content.Settings.IpLimit != "" {
+		return d.Content.Settings.IpLimit
+	}
+	return IpLimitDisable
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"os"
+	"time"
+)
+
+// JsonStorage keeps the whole content in a single JSON file, and its history in another one.
+// It has no partial writes, so every commit rewrites both files.
+type JsonStorage struct {
+	path        string
+	historyPath string
+	sealer      *Sealer
+}
+
+func (s *JsonStorage) Exists() bool {
+	return utils.FileExist(s.path)
+}
+
+func (s *JsonStorage) Load(content *Content) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(decodeFile(data, content, s.sealer))
+}
+
+func (s *JsonStorage) LoadHistory(history *History) error {
+	if !utils.FileExist(s.historyPath) {
+		return nil
+	}
+	data, err := os.ReadFile(s.historyPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(json.Unmarshal(data, history))
+}
+
+func (s *JsonStorage) Save(content *Content) error {
+	c := *content
+	c.History = nil
+	data, err := encodeFile(&c, s.sealer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = utils.WriteFile(s.path, data, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if content.History == nil {
+		return nil
+	}
+	if data, err = json.Marshal(content.History); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(utils.WriteFile(s.historyPath, data, 0600))
+}
+
+func (s *JsonStorage) Commit(content *Content, _ *Changes) error {
+	return s.Save(content)
+}
+
+// Discard moves the content file aside; the history file is kept for the recovered content.
+func (s *JsonStorage) Discard() (string, error) {
+	path := fmt.Sprintf("%s.broken-%d", s.path, time.Now().Unix())
+	return path, errors.WithStack(os.Rename(s.path, path))
+}
+
+func (s *JsonStorage) Close() error {
+	return nil
+}
+
+func newJsonStorage(path, historyPath string, sealer *Sealer) *JsonStorage {
+	return &JsonStorage{path: path, historyPath: historyPath, sealer: sealer}
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"time"
+)
+
+// Ledger entry types
+const (
+	LedgerTypeTopup      = "topup"
+	LedgerTypeAdjustment = "adjustment"
+)
+
+// LedgerEntry is a change of the quota (top-up) or the usage (adjustment) of a user.
+type LedgerEntry struct {
+	Type   string  `json:"type"`
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+	Actor  string  `json:"actor"`
+	Time   int64   `json:"time"`
+	Quota  float64 `json:"quota"`
+	Usage  float64 `json:"usage"`
+}
+
+// Topup adds the amount (GB) to the quota of the user and records it in the ledger.
+func (d *Database) Topup(u *User, amount float64, reason, actor string, t time.Time) *LedgerEntry {
+	u.Quota = utils.RoundFloat(u.Quota+amount, 2)
+	return d.recordLedger(u, LedgerTypeTopup, amount, reason, actor, t)
+}
+
+// AdjustUsage adds the amount (GB, negative to deduct) to the usage of the user and records it in the ledger.
+func (d *Database) AdjustUsage(u *User, amount float64, reason, actor string, t time.Time) *LedgerEntry {
+	u.AdjustUsage(int64(amount * 1000 * 1000 * 1000))
+	return d.recordLedger(u, LedgerTypeAdjustment, amount, reason, actor, t)
+}
+
+// UserLedger returns the ledger entries of the user, the newest first.
+func (d *Database) UserLedger(id int) []*LedgerEntry {
+	entries := d.Content.History.Ledger[id]
+	result := make([]*LedgerEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		result = append(result, entries[i])
+	}
+	return result
+}
+
+func (d *Database) recordLedger(u *User, kind string, amount float64, reason, actor string, t time.Time) *LedgerEntry {
+	entry := &LedgerEntry{
+		Type:   kind,
+		Amount: amount,
+		Reason: reason,
+		Actor:  actor,
+		Time:   t.UnixMilli(),
+		Quota:  u.Quota,
+		Usage:  u.Usage,
+	}
+
+	entries := append(d.Content.History.Ledger[u.Id], entry)
+	if count := d.c.History.Ledger; len(entries) > count {
+		entries = entries[len(entries)-count:]
+	}
+	if len(entries) > 0 {
+		d.Content.History.Ledger[u.Id] = entries
+	} else {
+		delete(d.Content.History.Ledger, u.Id)
+	}
+
+	return entry
+}
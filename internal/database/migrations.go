@@ -0,0 +1,95 @@
+package database
+
+import (
+	"time"
+)
+
+// Migration upgrades the content from the previous schema version to the next one.
+type Migration struct {
+	Name string
+	Up   func(c *Content)
+}
+
+// migrations run in order on load; the schema version of the content is the number of applied migrations.
+// Append new migrations to the end and never reorder or remove the existing ones.
+var migrations = []Migration{
+	{
+		Name: "backfill_users_usage_reset_at",
+		Up: func(c *Content) {
+			for _, user := range c.Users {
+				if user.UsageResetAt == 0 {
+					user.UsageResetAt = time.Now().UnixMilli()
+				}
+			}
+		},
+	},
+	{
+		// The direction of the traffic recorded before the split is unknown, so it is counted as downlink.
+		Name: "backfill_traffic_directions",
+		Up: func(c *Content) {
+			for _, user := range c.Users {
+				user.Downlink = user.Usage
+				user.DownlinkBytes = user.UsageBytes
+			}
+			for _, node := range c.Nodes {
+				node.Downlink = node.Usage
+			}
+			c.Stats.TotalDownlink = c.Stats.TotalUsage
+		},
+	},
+	{
+		// The monthly reset of older versions wrote Unix seconds.
+		Name: "normalize_users_usage_reset_at",
+		Up: func(c *Content) {
+			for _, user := range c.Users {
+				user.UsageResetAt = Millis(user.UsageResetAt)
+			}
+		},
+	},
+	{
+		// Older versions did not keep the reasons, which the usage resets now need to enable only the users
+		// disabled over quota.
+		// The expiration and quota rules are inlined as they were at this version, so later changes do not change it.
+		Name: "backfill_users_disabled_reason",
+		Up: func(c *Content) {
+			now := time.Now().UnixMilli()
+			for _, user := range c.Users {
+				if user.Enabled || user.DisabledReason != "" {
+					continue
+				}
+				policy, usage := user.QuotaPolicy, user.Usage
+				if policy == "" {
+					policy = c.Settings.QuotaPolicy
+				}
+				if policy == QuotaPolicyDownlink {
+					usage = user.Downlink
+				}
+				if user.ExpiresAt > 0 && user.ExpiresAt <= now {
+					user.DisabledReason = DisabledReasonExpired
+				} else if user.Quota > 0 && usage > user.Quota {
+					user.DisabledReason = DisabledReasonQuota
+				}
+			}
+		},
+	},
+	{
+		// The server key must stay the same, as the profile links of the users of 2022 methods hold it.
+		Name: "generate_shadowsocks_server_key",
+		Up: func(c *Content) {
+			if c.Settings.SsServerKey == "" {
+				c.Settings.SsServerKey = generateServerKey()
+			}
+		},
+	},
+	{
+		// Older versions kept the history in the content, and it is stored apart now.
+		// The content is loaded with it in place and saved as a whole after the migrations, which moves it.
+		Name: "store_history_apart",
+		Up:   func(c *Content) {},
+	},
+}
+
+// SchemaVersion returns the schema version this build works with.
+func SchemaVersion() int {
+	return len(migrations)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrateOldFile loads a database file written by the first version, with no schema version at all.
+func TestMigrateOldFile(t *testing.T) {
+	d := newTestDatabase(t, DriverJson)
+	data, err := os.ReadFile(filepath.Join("testdata", "app-v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(d.c.Env.DatabasePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.storage.Close() }()
+
+	if d.Content.Version != SchemaVersion() {
+		t.Errorf("version = %d, want %d", d.Content.Version, SchemaVersion())
+	}
+	if key, err := base64.StdEncoding.DecodeString(d.Content.Settings.SsServerKey); err != nil || len(key) == 0 {
+		t.Errorf("server key = %q, want a generated one", d.Content.Settings.SsServerKey)
+	}
+
+	tests := []struct {
+		id           int
+		reason       string
+		usageResetAt int64
+		downlink     float64
+	}{
+		{1, "", 1700000000000, 10.5},
+		{2, DisabledReasonQuota, 1700000000000, 20},
+		{3, "", 0, 0},
+	}
+	for _, tt := range tests {
+		u := d.Content.Users[tt.id-1]
+		if u.DisabledReason != tt.reason {
+			t.Errorf("user #%d disabled reason = %q, want %q", tt.id, u.DisabledReason, tt.reason)
+		}
+		if tt.usageResetAt > 0 && u.UsageResetAt != tt.usageResetAt {
+			t.Errorf("user #%d usage reset at = %d, want %d", tt.id, u.UsageResetAt, tt.usageResetAt)
+		}
+		if u.Downlink != tt.downlink {
+			t.Errorf("user #%d downlink = %v, want %v", tt.id, u.Downlink, tt.downlink)
+		}
+	}
+
+	backups, err := filepath.Glob(fmt.Sprintf(d.c.Env.DatabaseBackupPath, "v0-*") + "*")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("migration backups = %v, want one", backups)
+	}
+	// The content before the migrations has no server key, so the migration generates it.
+	if old, err := d.read(backups[0]); err != nil || old.Settings.SsServerKey != "" {
+		t.Errorf("server key before the migrations = %v (err: %v), want none", old, err)
+	}
+
+	// The migrated database loads as it is, with the same server key.
+	key := d.Content.Settings.SsServerKey
+	if err = d.storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Content.Settings.SsServerKey != key {
+		t.Errorf("server key changed after reload")
+	}
+}
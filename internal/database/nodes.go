@@ -17,5 +17,14 @@ type Node struct {
 	HttpToken string     `json:"http_token" validate:"required"`
 	HttpPort  int        `json:"http_port" validate:"required,min=1,max=65536"`
 	Usage     float64    `json:"usage"`
+	Uplink    float64    `json:"uplink"`
+	Downlink  float64    `json:"downlink"`
 	Status    NodeStatus `json:"status"`
 }
+
+// SetUsage sets the usage (GB) of the node, counting it as downlink like the usage of users set by hand.
+func (n *Node) SetUsage(usage float64) {
+	n.Usage = usage
+	n.Uplink = 0
+	n.Downlink = usage
+}
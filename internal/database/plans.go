@@ -0,0 +1,73 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// Protocols are the inbounds users connect through.
+const (
+	ProtocolDirect  = "direct"
+	ProtocolRelay   = "relay"
+	ProtocolReverse = "reverse"
+)
+
+// Plan is a template of user limits, like a subscription package sold to customers.
+type Plan struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name" validate:"required,min=1,max=64"`
+	Quota       float64  `json:"quota" validate:"min=0"`
+	Duration    int      `json:"duration" validate:"min=0,max=3650"`
+	ResetPolicy string   `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly anniversary days"`
+	ResetDays   int      `json:"reset_days" validate:"required_if=ResetPolicy days,min=0,max=3650"`
+	Protocols   []string `json:"protocols" validate:"dive,oneof=direct relay reverse"`
+	CreatedAt   int64    `json:"created_at"`
+}
+
+// Apply sets the limits of the plan on the user and makes it a subscriber.
+// The expiration date is not changed, see Subscribe.
+func (p *Plan) Apply(u *User) {
+	u.PlanId = p.Id
+	u.Quota = p.Quota
+	u.ResetPolicy = p.ResetPolicy
+	u.ResetDays = p.ResetDays
+	u.Protocols = slices.Clone(p.Protocols)
+}
+
+// ApplyPlan applies the plan on the user like Apply, and enables or unflags the user the new quota is enough for.
+// The quota of the plan replaces the quota of the user, so the top-ups of the user are cancelled.
+func (d *Database) ApplyPlan(p *Plan, u *User, t time.Time) {
+	p.Apply(u)
+	d.ReleaseQuota(u, t)
+}
+
+// Subscribe applies the plan on the user and sets the expiration date by the duration of the plan from the given time.
+func (p *Plan) Subscribe(u *User, t time.Time) {
+	p.Apply(u)
+	if p.Duration > 0 {
+		u.ExpiresAt = t.AddDate(0, 0, p.Duration).UnixMilli()
+	} else {
+		u.ExpiresAt = 0
+	}
+}
+
+// FindPlan returns the plan with the given id, or nil.
+func (d *Database) FindPlan(id int) *Plan {
+	for _, p := range d.Content.Plans {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// GeneratePlanId returns a new plan id and counts it, so the ids of the deleted plans are never reused.
+// The users may still refer to them, in the backups for instance.
+func (d *Database) GeneratePlanId() int {
+	id := d.Content.LastPlanId
+	for _, p := range d.Content.Plans {
+		id = max(id, p.Id)
+	}
+	d.Content.LastPlanId = id + 1
+	return d.Content.LastPlanId
+}
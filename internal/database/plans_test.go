@@ -0,0 +1,74 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeneratePlanId(t *testing.T) {
+	tests := []struct {
+		name  string
+		last  int
+		plans []int
+		want  int
+	}{
+		{"first plan", 0, nil, 1},
+		{"after the plans", 2, []int{1, 2}, 3},
+		{"after the deleted last plan", 3, []int{1, 2}, 4},
+		{"after every plan deleted", 3, nil, 4},
+		{"content without the counter", 0, []int{1, 5}, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(nil, nil)
+			d.Content.LastPlanId = tt.last
+			for _, id := range tt.plans {
+				d.Content.Plans = append(d.Content.Plans, &Plan{Id: id})
+			}
+
+			if id := d.GeneratePlanId(); id != tt.want {
+				t.Errorf("id = %d, want %d", id, tt.want)
+			}
+			if d.Content.LastPlanId != tt.want {
+				t.Errorf("last plan id = %d, want %d", d.Content.LastPlanId, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		quota     float64
+		wantQuota float64
+		enabled   bool
+	}{
+		{"enough for the usage", 20, 20, true},
+		{"cancels the top-ups", 10, 10, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(nil, nil)
+			// The quota of 10 GB of the plan with a top-up of 5 GB.
+			u := &User{Id: 1, Quota: 15}
+			u.SetUsage(12)
+			u.Disable(DisabledReasonQuota)
+			u.QuotaWarning, u.QuotaExceeded = 95, true
+
+			d.ApplyPlan(&Plan{Id: 1, Quota: tt.quota}, u, now)
+			if u.Quota != tt.wantQuota {
+				t.Errorf("quota = %v, want %v", u.Quota, tt.wantQuota)
+			}
+			if u.Enabled != tt.enabled {
+				t.Errorf("enabled = %v, want %v", u.Enabled, tt.enabled)
+			}
+			if tt.enabled && (u.QuotaWarning != 0 || u.QuotaExceeded) {
+				t.Errorf("flags = %d, %v, want 0, false", u.QuotaWarning, u.QuotaExceeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"time"
+)
+
+// Quota limits define what happens to users over their quotas and overage allowances.
+const (
+	QuotaLimitHard = "hard"
+	QuotaLimitSoft = "soft"
+)
+
+// Overage units define how the overage allowance is added to the quota.
+const (
+	OverageUnitPercent = "percent"
+	OverageUnitGb      = "gb"
+)
+
+// QuotaLimit returns the quota limit of the user: hard limits disable users, and soft limits only flag them.
+// The limit of the settings applies when the user has none.
+func (d *Database) QuotaLimit(u *User) string {
+	if u.QuotaLimit != "" {
+		return u.QuotaLimit
+	}
+	if d.Content.Settings.QuotaLimit != "" {
+		return d.Content.Settings.QuotaLimit
+	}
+	return QuotaLimitHard
+}
+
+// Allowance returns the usage (GB) the user can reach before the quota limit applies, the quota and its overage.
+// The overage of the settings applies when the user has no overage unit.
+func (d *Database) Allowance(u *User) float64 {
+	overage, unit := u.Overage, u.OverageUnit
+	if unit == "" {
+		overage, unit = d.Content.Settings.Overage, d.Content.Settings.OverageUnit
+	}
+
+	switch unit {
+	case OverageUnitPercent:
+		return u.Quota + u.Quota*overage/100
+	case OverageUnitGb:
+		return u.Quota + overage
+	default:
+		return u.Quota
+	}
+}
+
+// OverAllowance reports whether the user has a quota and has used more than its allowance.
+func (d *Database) OverAllowance(u *User) bool {
+	return u.Quota > 0 && u.QuotaUsage(d.Content.Settings.QuotaPolicy) > d.Allowance(u)
+}
+
+// RefreshQuotaFlags lowers the quota flags of the user to its usage, after changes of the quota or the usage.
+// The flags are only lowered, as the coordinator raises them when it notifies the users.
+func (d *Database) RefreshQuotaFlags(u *User) {
+	if !d.OverAllowance(u) {
+		u.QuotaExceeded = false
+	}
+	u.QuotaWarning = min(u.QuotaWarning, d.reachedQuotaWarning(u))
+}
+
+// ReleaseQuota enables the user disabled over quota once it is within its allowance, unless it has expired meanwhile,
+// and lowers its quota flags, after changes of the quota or the usage.
+func (d *Database) ReleaseQuota(u *User, t time.Time) {
+	if !u.Enabled && u.DisabledReason == DisabledReasonQuota && !d.OverAllowance(u) && !u.Expired(t) {
+		u.Enable()
+	}
+	d.RefreshQuotaFlags(u)
+}
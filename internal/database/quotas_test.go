@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshQuotaFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    float64
+		usage    float64
+		warning  int
+		exceeded bool
+		want     int
+		wantOver bool
+	}{
+		{"topup below every warning", 100, 50, 95, true, 0, false},
+		{"topup below the higher warning", 100, 90, 95, true, 80, false},
+		{"still over the quota", 100, 120, 95, true, 95, true},
+		{"higher warnings are left to the coordinator", 100, 96, 80, false, 80, false},
+		{"no quota", 0, 500, 95, true, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(nil, nil)
+			u := &User{Quota: tt.quota, QuotaWarning: tt.warning, QuotaExceeded: tt.exceeded}
+			u.SetUsage(tt.usage)
+			u.QuotaWarning, u.QuotaExceeded = tt.warning, tt.exceeded
+
+			d.RefreshQuotaFlags(u)
+			if u.QuotaWarning != tt.want || u.QuotaExceeded != tt.wantOver {
+				t.Errorf("flags = %d, %v, want %d, %v", u.QuotaWarning, u.QuotaExceeded, tt.want, tt.wantOver)
+			}
+		})
+	}
+}
+
+func TestReleaseQuota(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		usage     float64
+		expiresAt int64
+		reason    string
+		want      bool
+	}{
+		{"within the allowance", 50, 0, DisabledReasonQuota, true},
+		{"still over the allowance", 120, 0, DisabledReasonQuota, false},
+		{"expired", 50, now.Add(-time.Hour).UnixMilli(), DisabledReasonQuota, false},
+		{"not expired yet", 50, now.Add(time.Hour).UnixMilli(), DisabledReasonQuota, true},
+		{"disabled by the admin", 50, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(nil, nil)
+			u := &User{Quota: 100, ExpiresAt: tt.expiresAt}
+			u.SetUsage(tt.usage)
+			u.Enabled, u.DisabledReason = false, tt.reason
+
+			d.ReleaseQuota(u, now)
+			if u.Enabled != tt.want {
+				t.Errorf("enabled = %v, want %v", u.Enabled, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"time"
+)
+
+// Reset policies define the periods of user usages; the usage is reset at the start of each period.
+const (
+	ResetPolicyNone        = "none"
+	ResetPolicyDaily       = "daily"
+	ResetPolicyWeekly      = "weekly"
+	ResetPolicyMonthly     = "monthly"
+	ResetPolicyAnniversary = "anniversary"
+	ResetPolicyDays        = "days"
+)
+
+// Period is the usage of a user in a past reset period.
+type Period struct {
+	From          int64   `json:"from"`
+	To            int64   `json:"to"`
+	Usage         float64 `json:"usage"`
+	UsageBytes    int64   `json:"usage_bytes"`
+	UplinkBytes   int64   `json:"uplink_bytes"`
+	DownlinkBytes int64   `json:"downlink_bytes"`
+}
+
+// Location returns the timezone of the settings, the server timezone by default.
+func (d *Database) Location() *time.Location {
+	if d.Content.Settings.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(d.Content.Settings.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ResetPolicy returns the reset policy of the user and its number of days.
+// The policy of the settings applies when the user has none.
+func (d *Database) ResetPolicy(u *User) (string, int) {
+	if u.ResetPolicy != "" {
+		return u.ResetPolicy, u.ResetDays
+	}
+	if d.Content.Settings.ResetPolicy != "" {
+		return d.Content.Settings.ResetPolicy, d.Content.Settings.ResetDays
+	}
+	return ResetPolicyNone, 0
+}
+
+// ShouldResetUsage reports whether a new reset period of the user has started since its last reset.
+func (d *Database) ShouldResetUsage(u *User, t time.Time) bool {
+	policy, days := d.ResetPolicy(u)
+	start, ok := periodStartOf(policy, days, time.UnixMilli(u.CreatedAt), t.In(d.Location()))
+	return ok && u.UsageResetAt < start.UnixMilli()
+}
+
+// ResetUsage archives the usage of the current period of the user and resets it.
+func (d *Database) ResetUsage(u *User, t time.Time) {
+	periods := append(d.Content.History.Periods[u.Id], &Period{
+		From:          u.UsageResetAt,
+		To:            t.UnixMilli(),
+		Usage:         u.Usage,
+		UsageBytes:    u.UsageBytes,
+		UplinkBytes:   u.UplinkBytes,
+		DownlinkBytes: u.DownlinkBytes,
+	})
+	if count := d.c.History.Periods; len(periods) > count {
+		periods = periods[len(periods)-count:]
+	}
+	if len(periods) > 0 {
+		d.Content.History.Periods[u.Id] = periods
+	} else {
+		delete(d.Content.History.Periods, u.Id)
+	}
+
+	u.SetUsage(0)
+	u.UsageResetAt = t.UnixMilli()
+}
+
+// UserPeriods returns the archived usages of the past periods of the user, the newest first.
+func (d *Database) UserPeriods(id int) []*Period {
+	periods := d.Content.History.Periods[id]
+	result := make([]*Period, 0, len(periods))
+	for i := len(periods) - 1; i >= 0; i-- {
+		result = append(result, periods[i])
+	}
+	return result
+}
+
+// periodStartOf returns the start of the reset period containing the given time.
+// The anchor (the creation time of the user) is the start of the anniversary and N-day periods.
+// It returns false for the policies without periods.
+func periodStartOf(policy string, days int, anchor, t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+	switch policy {
+	case ResetPolicyDaily:
+		return midnight, true
+	case ResetPolicyWeekly:
+		return midnight.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)), true
+	case ResetPolicyMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), true
+	case ResetPolicyAnniversary:
+		start := anniversary(t.Year(), t.Month(), anchor.In(loc).Day(), loc)
+		if start.After(t) {
+			start = anniversary(t.Year(), t.Month()-1, anchor.In(loc).Day(), loc)
+		}
+		return start, true
+	case ResetPolicyDays:
+		if days < 1 {
+			return time.Time{}, false
+		}
+		a := anchor.In(loc)
+		start := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, loc)
+		if start.After(t) {
+			return start, true
+		}
+		elapsed := int(midnight.Sub(start).Hours()+12) / 24
+		return start.AddDate(0, 0, elapsed-elapsed%days), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// anniversary returns the given day of the month, or the last day for shorter months.
+func anniversary(year int, month time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	return time.Date(year, month, min(day, last), 0, 0, 0, 0, loc)
+}
+
+// Millis converts Unix seconds, written for usage resets by older versions, into Unix milliseconds.
+func Millis(ts int64) int64 {
+	if ts > 0 && ts < 100_000_000_000 {
+		return ts * 1000
+	}
+	return ts
+}
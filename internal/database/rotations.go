@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// PreviousClientEmail returns the email of the Xray client of the previous password of the user, "<user>.0",
+// which is named like the clients of the credentials (with no credential of its own) to count for the user in the stats.
+func PreviousClientEmail(u *User) string {
+	return fmt.Sprintf("%d.0", u.Id)
+}
+
+// RotateIdentity gives the user a new identity (profile URL).
+// The previous one keeps working until the given time (Unix milliseconds), or stops at once for zero.
+func (d *Database) RotateIdentity(u *User, until int64) {
+	u.PreviousIdentity, u.PreviousIdentityUntil = "", 0
+	if until > 0 {
+		u.PreviousIdentity, u.PreviousIdentityUntil = u.Identity, until
+	}
+	u.Identity = d.GenerateUserIdentity()
+}
+
+// RotatePassword gives the user a new password.
+// The previous one keeps working until the given time (Unix milliseconds), or stops at once for zero.
+func (d *Database) RotatePassword(u *User, until int64) {
+	u.PreviousPassword, u.PreviousPasswordUntil = "", 0
+	if until > 0 {
+		u.PreviousPassword, u.PreviousPasswordUntil = u.ShadowsocksPassword, until
+	}
+	u.ShadowsocksPassword = d.GenerateUserPassword(u.ShadowsocksMethod)
+}
+
+// ExpireGrace drops the previous identity and password of the user whose grace periods have passed at the given time,
+// and reports which ones are dropped.
+func (u *User) ExpireGrace(t time.Time) (identity, password bool) {
+	if u.PreviousIdentity != "" && u.PreviousIdentityUntil <= t.UnixMilli() {
+		u.PreviousIdentity, u.PreviousIdentityUntil = "", 0
+		identity = true
+	}
+	if u.PreviousPassword != "" && u.PreviousPasswordUntil <= t.UnixMilli() {
+		u.PreviousPassword, u.PreviousPasswordUntil = "", 0
+		password = true
+	}
+	return identity, password
+}
+
+// ReclaimPrevious drops the previous identity or password the user has been given back, which ends its grace period.
+func (u *User) ReclaimPrevious() {
+	if u.PreviousIdentity != "" && u.PreviousIdentity == u.Identity {
+		u.PreviousIdentity, u.PreviousIdentityUntil = "", 0
+	}
+	if u.PreviousPassword != "" && u.PreviousPassword == u.ShadowsocksPassword {
+		u.PreviousPassword, u.PreviousPasswordUntil = "", 0
+	}
+}
+
+// FindUserByIdentity returns the user with the (current) identity, or nil.
+func (d *Database) FindUserByIdentity(identity string) *User {
+	if identity == "" {
+		return nil
+	}
+	for _, u := range d.Content.Users {
+		if u.Identity == identity {
+			return u
+		}
+	}
+	return nil
+}
+
+// FindUserByPreviousIdentity returns the user with the previous identity during its grace period, or nil.
+// The previous identity only shows what the user had before the rotation, so it must not be used to change anything.
+func (d *Database) FindUserByPreviousIdentity(identity string, t time.Time) *User {
+	if identity == "" {
+		return nil
+	}
+	for _, u := range d.Content.Users {
+		if u.PreviousIdentity == identity && u.PreviousIdentityUntil > t.UnixMilli() {
+			return u
+		}
+	}
+	return nil
+}
+
+// PreviousView returns a copy of the user as it was before the rotation, for its previous identity.
+// It has the previous password during its grace period, or no password (and so no links) at all,
+// and none of the credentials, so the previous identity never reveals the new secrets.
+func (u *User) PreviousView(t time.Time) *User {
+	v := *u
+	v.Identity, v.ShadowsocksPassword = u.PreviousIdentity, ""
+	if u.PreviousPassword != "" && u.PreviousPasswordUntil > t.UnixMilli() {
+		v.ShadowsocksPassword = u.PreviousPassword
+	}
+	v.PreviousIdentity, v.PreviousIdentityUntil = "", 0
+	v.PreviousPassword, v.PreviousPasswordUntil = "", 0
+	v.Credentials = nil
+	return &v
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGraceRules(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		until        int64
+		at           time.Time
+		previous     bool
+		wantIdentity bool
+		wantPassword string
+	}{
+		{"no grace", 0, now, false, false, ""},
+		{"during grace", now.Add(time.Hour).UnixMilli(), now, true, false, "old-password"},
+		{"at the end of grace", now.UnixMilli(), now, false, true, ""},
+		{"after grace", now.Add(-time.Minute).UnixMilli(), now, false, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(nil, nil)
+			u := &User{Id: 1, Identity: "old-identity", ShadowsocksPassword: "old-password"}
+			u.ShadowsocksMethod = "chacha20-ietf-poly1305"
+			d.Content.Users = []*User{u}
+
+			d.RotateIdentity(u, tt.until)
+			d.RotatePassword(u, tt.until)
+
+			if u.Identity == "old-identity" || u.ShadowsocksPassword == "old-password" {
+				t.Fatalf("rotation kept the old secrets")
+			}
+			if d.FindUserByIdentity("old-identity") != nil {
+				t.Errorf("the previous identity matched as the current one")
+			}
+			if d.FindUserByIdentity(u.Identity) != u {
+				t.Errorf("the new identity did not match")
+			}
+			if found := d.FindUserByPreviousIdentity("old-identity", tt.at) != nil; found != tt.previous {
+				t.Errorf("previous identity found = %v, want %v", found, tt.previous)
+			}
+
+			v := u.PreviousView(tt.at)
+			if v.ShadowsocksPassword != tt.wantPassword {
+				t.Errorf("previous view password = %q, want %q", v.ShadowsocksPassword, tt.wantPassword)
+			}
+			if v.Identity == u.Identity || v.PreviousIdentity != "" || v.PreviousPassword != "" {
+				t.Errorf("previous view reveals the new or previous secrets: %+v", v)
+			}
+
+			identity, password := u.ExpireGrace(tt.at)
+			if identity != tt.wantIdentity || password != tt.wantIdentity {
+				t.Errorf("ExpireGrace() = %v, %v, want %v", identity, password, tt.wantIdentity)
+			}
+			if tt.wantIdentity && (u.PreviousIdentity != "" || u.PreviousPassword != "") {
+				t.Errorf("expired grace kept the previous secrets")
+			}
+		})
+	}
+}
+
+func TestSecretsTaken(t *testing.T) {
+	d := New(nil, nil)
+	until := time.Now().Add(time.Hour).UnixMilli()
+	u := &User{Id: 1, Identity: "identity", ShadowsocksPassword: "password",
+		PreviousIdentity: "previous-identity", PreviousIdentityUntil: until,
+		PreviousPassword: "previous-password", PreviousPasswordUntil: until,
+		Credentials: []*Credential{{Id: 1, Password: "credential-password"}},
+	}
+	other := &User{Id: 2, Identity: "other-identity", ShadowsocksPassword: "other-password"}
+	d.Content.Users = []*User{u, other}
+
+	tests := []struct {
+		name     string
+		secret   string
+		except   *User
+		password bool
+		identity bool
+	}{
+		{"own secrets", "password", u, false, false},
+		{"own previous secrets", "previous-password", u, false, false},
+		{"own credential password", "credential-password", u, true, false},
+		{"secrets of others", "password", other, true, false},
+		{"previous secrets of others", "previous-password", other, true, false},
+		{"identity of others", "identity", other, false, true},
+		{"previous identity of others", "previous-identity", other, false, true},
+		{"own previous identity", "previous-identity", u, false, false},
+		{"new users", "credential-password", nil, true, false},
+		{"free secrets", "free", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if taken := d.IsPasswordTaken(tt.secret, tt.except); taken != tt.password {
+				t.Errorf("IsPasswordTaken() = %v, want %v", taken, tt.password)
+			}
+			if taken := d.IsIdentityTaken(tt.secret, tt.except); taken != tt.identity {
+				t.Errorf("IsIdentityTaken() = %v, want %v", taken, tt.identity)
+			}
+		})
+	}
+
+	u.Identity, u.ShadowsocksPassword = "previous-identity", "previous-password"
+	u.ReclaimPrevious()
+	if u.PreviousIdentity != "" || u.PreviousPassword != "" || u.PreviousIdentityUntil != 0 || u.PreviousPasswordUntil != 0 {
+		t.Errorf("the reclaimed secrets are still in grace: %+v", u)
+	}
+}
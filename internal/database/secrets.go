@@ -0,0 +1,479 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"os"
+	"strings"
+)
+
+// sealedPrefix marks encrypted values, so plain values written before encryption are still readable.
+const sealedPrefix = "enc:v1:"
+
+// IsSealed reports whether the value has the prefix of the sealed values.
+// Plain values like this cannot be stored, as they would be taken for sealed ones and fail to open.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Sealer encrypts the sensitive fields of the content (passwords and tokens) with AES-256-GCM.
+// It seals with the first key and opens with any of them, so the content stays readable during key rotations.
+type Sealer struct {
+	ciphers []cipher.AEAD
+}
+
+// Seal encrypts the given value.
+func (s *Sealer) Seal(value string) (string, error) {
+	if value == "" || strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	nonce := make([]byte, s.ciphers[0].NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.WithStack(err)
+	}
+	sealed := s.ciphers[0].Seal(nonce, nonce, []byte(value), nil)
+
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts the given value; plain values are returned as they are.
+func (s *Sealer) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for _, c := range s.ciphers {
+		if len(sealed) < c.NonceSize() {
+			continue
+		}
+		if plain, err := c.Open(nil, sealed[:c.NonceSize()], sealed[c.NonceSize():], nil); err == nil {
+			return string(plain), nil
+		}
+	}
+
+	return "", errors.New("cannot decrypt value, wrong database key")
+}
+
+func (s *Sealer) sealSettings(settings *Settings) (*Settings, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	sealed := *settings
+	var err error
+	if sealed.AdminPassword, err = s.Seal(settings.AdminPassword); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if sealed.TelegramToken, err = s.Seal(settings.TelegramToken); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sealed.SsServerKey, err = s.Seal(settings.SsServerKey)
+	return &sealed, errors.WithStack(err)
+}
+
+func (s *Sealer) sealUser(user *User) (*User, error) {
+	sealed := *user
+	var err error
+	if sealed.ShadowsocksPassword, err = s.Seal(user.ShadowsocksPassword); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if sealed.PreviousPassword, err = s.Seal(user.PreviousPassword); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if user.Credentials != nil {
+		sealed.Credentials = make([]*Credential, len(user.Credentials))
+	}
+	for i, c := range user.Credentials {
+		credential := *c
+		if credential.Password, err = s.Seal(c.Password); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sealed.Credentials[i] = &credential
+	}
+	return &sealed, nil
+}
+
+func (s *Sealer) sealNode(node *Node) (*Node, error) {
+	sealed := *node
+	var err error
+	sealed.HttpToken, err = s.Seal(node.HttpToken)
+	return &sealed, errors.WithStack(err)
+}
+
+// SealContent returns a copy of the content with the sensitive fields encrypted.
+func (s *Sealer) SealContent(content *Content) (*Content, error) {
+	sealed := *content
+
+	var err error
+	if sealed.Settings, err = s.sealSettings(content.Settings); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sealed.Users = make([]*User, len(content.Users))
+	for i, u := range content.Users {
+		if sealed.Users[i], err = s.sealUser(u); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	sealed.Nodes = make([]*Node, len(content.Nodes))
+	for i, n := range content.Nodes {
+		if sealed.Nodes[i], err = s.sealNode(n); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return &sealed, nil
+}
+
+func (s *Sealer) openSettings(settings *Settings) (err error) {
+	if settings == nil {
+		return nil
+	}
+	if settings.AdminPassword, err = s.Open(settings.AdminPassword); err != nil {
+		return errors.WithStack(err)
+	}
+	if settings.TelegramToken, err = s.Open(settings.TelegramToken); err != nil {
+		return errors.WithStack(err)
+	}
+	settings.SsServerKey, err = s.Open(settings.SsServerKey)
+	return errors.WithStack(err)
+}
+
+func (s *Sealer) openUser(user *User) (err error) {
+	if user.ShadowsocksPassword, err = s.Open(user.ShadowsocksPassword); err != nil {
+		return errors.WithStack(err)
+	}
+	if user.PreviousPassword, err = s.Open(user.PreviousPassword); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, c := range user.Credentials {
+		if c.Password, err = s.Open(c.Password); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+func (s *Sealer) openNode(node *Node) (err error) {
+	node.HttpToken, err = s.Open(node.HttpToken)
+	return errors.WithStack(err)
+}
+
+// OpenContent decrypts the sensitive fields of the content in place.
+func (s *Sealer) OpenContent(content *Content) error {
+	if err := s.openSettings(content.Settings); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, u := range content.Users {
+		if err := s.openUser(u); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	for _, n := range content.Nodes {
+		if err := s.openNode(n); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// NewSealer creates a sealer that seals with the first key and opens with any of them.
+func NewSealer(keys ...[]byte) (*Sealer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no database key")
+	}
+
+	s := &Sealer{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		c, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.ciphers = append(s.ciphers, c)
+	}
+
+	return s, nil
+}
+
+// GenerateKey generates a random 256-bit database key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, errors.WithStack(err)
+}
+
+// EncodeKey encodes the key the way it is kept in the key file and the environment variable.
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// LoadKeys loads the database keys from the environment variable or the key file, the sealing key first.
+// The environment variable may hold more comma-separated keys, and the key file may have an old key file next to it
+// with the older keys, one per line (kept by rotations until everything is sealed again),
+// so the content sealed by older keys stays readable.
+// It generates a new key file on the first run.
+func LoadKeys(env *config.Env) ([][]byte, error) {
+	if value := os.Getenv(config.DatabaseKeyEnv); value != "" {
+		var keys [][]byte
+		for _, v := range strings.Split(value, ",") {
+			key, err := decodeKey(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot decode %s", config.DatabaseKeyEnv)
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	if !utils.FileExist(env.DatabaseKeyPath) {
+		key, err := GenerateKey()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return [][]byte{key}, errors.WithStack(SaveKey(env.DatabaseKeyPath, key))
+	}
+
+	var keys [][]byte
+	for _, path := range []string{env.DatabaseKeyPath, env.DatabaseKeyPath + ".old"} {
+		if !utils.FileExist(path) {
+			continue
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(value)), "\n") {
+			key, err := decodeKey(line)
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot decode %s", path)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// LoadSealer creates a sealer with the database keys.
+func LoadSealer(env *config.Env) (*Sealer, error) {
+	keys, err := LoadKeys(env)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return NewSealer(keys...)
+}
+
+// SaveKey writes the keys into the given key file, one per line.
+func SaveKey(path string, keys ...[]byte) error {
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = EncodeKey(key)
+	}
+	return errors.WithStack(utils.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600))
+}
+
+func decodeKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("the key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// RotateKey seals the stored content and the backups with a new key and returns it,
+// with the backups that cannot be read (and so are left as they are).
+// The key file is replaced first and all the old keys are kept next to it until everything is sealed again,
+// so an interrupted rotation (or a skipped backup) leaves nothing unreadable.
+// When the keys come from the environment variable, the caller must put the returned key there.
+func RotateKey(driver string, env *config.Env) ([]byte, []string, error) {
+	keys, err := LoadKeys(env)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	sealer, err := NewSealer(append([][]byte{key}, keys...)...)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	managed := os.Getenv(config.DatabaseKeyEnv) == ""
+	if managed {
+		if err = SaveKey(env.DatabaseKeyPath+".old", keys...); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if err = SaveKey(env.DatabaseKeyPath, key); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	storage, err := NewStorage(driver, env, sealer)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = storage.Close()
+	}()
+
+	if storage.Exists() {
+		content := newContent()
+		if err = storage.Load(content); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if err = storage.LoadHistory(content.History); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		if err = storage.Save(content); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	paths, err := backupPaths(env)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	var skipped []string
+	for _, path := range paths {
+		if err = resealBackup(path, sealer); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+
+	if managed && len(skipped) == 0 {
+		if err = os.Remove(env.DatabaseKeyPath + ".old"); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	return key, skipped, nil
+}
+
+// resealBackup seals the backup file again with the sealing key of the sealer, keeping its compression.
+// Only the sealed fields are rewritten, so the rest of the backup (like the fields missing from older versions)
+// stays as it is.
+func resealBackup(path string, sealer *Sealer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	compressed := isCompressed(data)
+	if data, err = decompress(data); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var f File
+	if err = json.Unmarshal(data, &f); err != nil {
+		return errors.WithStack(err)
+	}
+	raw := f.Content
+	if raw == nil {
+		raw = data
+	} else if sum := sha256.Sum256(raw); hex.EncodeToString(sum[:]) != f.Checksum {
+		return errors.Errorf("checksum mismatch, expected %s", f.Checksum)
+	}
+
+	if raw, err = resealObject(raw, sealer, sealedFields); err != nil {
+		return errors.WithStack(err)
+	}
+	sum := sha256.Sum256(raw)
+	if data, err = json.Marshal(&File{Checksum: hex.EncodeToString(sum[:]), Content: raw}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if compressed {
+		if data, err = compress(data); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(utils.WriteFile(path, data, 0600))
+}
+
+// sealedFields are the JSON paths of the sealed fields of the content, as sealed by SealContent.
+// The nested maps are for the objects (or the arrays of objects) holding more sealed fields.
+var sealedFields = map[string]any{
+	"settings": map[string]any{"admin_password": nil, "telegram_token": nil, "ss_server_key": nil},
+	"users": map[string]any{
+		"shadowsocks_password": nil,
+		"previous_password":    nil,
+		"credentials":          map[string]any{"password": nil},
+	},
+	"nodes": map[string]any{"http_token": nil},
+}
+
+// resealObject opens and seals again the given fields of the JSON object (or of each object of the JSON array).
+func resealObject(raw json.RawMessage, sealer *Sealer, fields map[string]any) (json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err == nil {
+		for i, item := range items {
+			if items[i], err = resealObject(item, sealer, fields); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		r, err := json.Marshal(items)
+		return r, errors.WithStack(err)
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil || object == nil {
+		// Nulls (and anything but objects) have no fields to reseal.
+		return raw, nil
+	}
+
+	for name, nested := range fields {
+		value, found := object[name]
+		if !found {
+			continue
+		}
+		var err error
+		if nested, ok := nested.(map[string]any); ok {
+			if object[name], err = resealObject(value, sealer, nested); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			continue
+		}
+
+		var s string
+		if err = json.Unmarshal(value, &s); err != nil {
+			continue
+		}
+		if s, err = sealer.Open(s); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if s, err = sealer.Seal(s); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if object[name], err = json.Marshal(s); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	r, err := json.Marshal(object)
+	return r, errors.WithStack(err)
+}
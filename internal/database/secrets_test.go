@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	oldKey, _ := GenerateKey()
+	newKey, _ := GenerateKey()
+
+	old, err := NewSealer(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewSealer(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSealer(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sealer  *Sealer
+		opener  *Sealer
+		value   string
+		wantErr bool
+	}{
+		{"round trip", old, old, "secret", false},
+		{"empty value", old, old, "", false},
+		{"opened by an old key after rotation", old, rotated, "secret", false},
+		{"sealed by the new key after rotation", rotated, other, "secret", false},
+		{"wrong key", old, other, "secret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.sealer.Seal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.value != "" && (sealed == tt.value || !strings.HasPrefix(sealed, sealedPrefix)) {
+				t.Fatalf("Seal() = %q, not sealed", sealed)
+			}
+
+			opened, err := tt.opener.Open(sealed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && opened != tt.value {
+				t.Errorf("Open() = %q, want %q", opened, tt.value)
+			}
+		})
+	}
+
+	if opened, _ := old.Open("plain"); opened != "plain" {
+		t.Errorf("Open() of a plain value = %q, want it as it is", opened)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	d := newTestDatabase(t, DriverJson)
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	u := newTestUser(d, 1)
+	d.Content.Users = append(d.Content.Users, u)
+	if err := d.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKeys, err := LoadKeys(d.c.Env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, err := d.writeBackup("20200101-00", d.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An older backup without the history keeps missing it.
+	older := fmt.Sprintf(d.c.Env.DatabaseBackupPath, "v1-20200101-000000")
+	data := `{"version":1,"settings":{"admin_password":"password"},"users":[],"nodes":[]}`
+	if err = os.WriteFile(older, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := fmt.Sprintf(d.c.Env.DatabaseBackupPath, "20200102-00")
+	if err = os.WriteFile(corrupt, []byte("{corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	key, skipped, err := RotateKey(DriverJson, d.c.Env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], corrupt) {
+		t.Errorf("skipped = %v, want only %s", skipped, corrupt)
+	}
+
+	keys, err := LoadKeys(d.c.Env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1+len(oldKeys) || string(keys[0]) != string(key) {
+		t.Errorf("the old keys are not kept while a backup is skipped")
+	}
+
+	sealer, err := NewSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewStorage(DriverJson, d.c.Env, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := newContent()
+	if err = storage.Load(content); err != nil {
+		t.Fatalf("the storage is not sealed with the new key: %v", err)
+	}
+	if content.Users[0].ShadowsocksPassword != u.ShadowsocksPassword {
+		t.Errorf("password = %q, want %q", content.Users[0].ShadowsocksPassword, u.ShadowsocksPassword)
+	}
+
+	for _, path := range []string{valid, older} {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err = decompress(raw); err != nil {
+			t.Fatal(err)
+		}
+		if path == older && (strings.Contains(string(raw), `"history"`) || strings.Contains(string(raw), `"ss_server_key"`)) {
+			t.Errorf("resealing added fields to %s: %s", path, raw)
+		}
+		if !strings.Contains(string(raw), sealedPrefix) {
+			t.Errorf("backup %s is not sealed: %s", path, raw)
+		}
+
+		content := newContent()
+		if err = decodeFile(raw, content, sealer); err != nil {
+			t.Fatalf("backup %s is not sealed with the new key: %v", path, err)
+		}
+		if path == older && content.Settings.AdminPassword != "password" {
+			t.Errorf("admin password = %q, want password", content.Settings.AdminPassword)
+		}
+	}
+
+	raw, _ := os.ReadFile(corrupt)
+	if string(raw) != "{corrupt" {
+		t.Errorf("the skipped backup is changed")
+	}
+}
@@ -8,5 +8,23 @@ type Settings struct {
 	SsDirectPort  int     `json:"ss_direct_port" validate:"min=0,max=65536"`
 	TrafficRatio  float64 `json:"traffic_ratio" validate:"min=1,max=1024"`
 	SingetServer  string  `json:"singet_server" validate:"omitempty,url"`
-	ResetPolicy   string  `json:"reset_policy" validate:"omitempty,oneof=monthly"`
+	ResetPolicy   string  `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly anniversary days"`
+	ResetDays     int     `json:"reset_days" validate:"required_if=ResetPolicy days,min=0,max=3650"`
+	Timezone      string  `json:"timezone" validate:"omitempty,timezone"`
+	QuotaPolicy   string  `json:"quota_policy" validate:"omitempty,oneof=total downlink"`
+	QuotaWarnings []int   `json:"quota_warnings" validate:"max=10,dive,min=1,max=99"`
+	QuotaLimit    string  `json:"quota_limit" validate:"omitempty,oneof=hard soft"`
+	Overage       float64 `json:"overage" validate:"min=0,max=1000000"`
+	OverageUnit   string  `json:"overage_unit" validate:"omitempty,oneof=percent gb"`
+	IpLimit       string  `json:"ip_limit" validate:"omitempty,oneof=disable flag"`
+	WebhookUrl    string  `json:"webhook_url" validate:"omitempty,url,max=512"`
+	TelegramToken string  `json:"telegram_token" validate:"max=128"`
+	TelegramChat  string  `json:"telegram_chat" validate:"required_with=TelegramToken,max=64"`
+
+	// The inbounds with 2022 methods take only the users of their methods, and the others take the rest.
+	SsReverseMethod string `json:"ss_reverse_method" validate:"omitempty,oneof=2022-blake3-aes-128-gcm 2022-blake3-aes-256-gcm"`
+	SsRelayMethod   string `json:"ss_relay_method" validate:"omitempty,oneof=2022-blake3-aes-128-gcm 2022-blake3-aes-256-gcm"`
+	SsDirectMethod  string `json:"ss_direct_method" validate:"omitempty,oneof=2022-blake3-aes-128-gcm 2022-blake3-aes-256-gcm"`
+	// SsServerKey is the server key (PSK) of the inbounds with 2022 methods; it cannot be changed by the admin.
+	SsServerKey string `json:"ss_server_key" validate:"omitempty,base64"`
 }
@@ -3,4 +3,6 @@ package database
 type Stats struct {
 	TotalUsageResetAt int64   `json:"total_usage_reset_at"`
 	TotalUsage        float64 `json:"total_usage"`
+	TotalUplink       float64 `json:"total_uplink"`
+	TotalDownlink     float64 `json:"total_downlink"`
 }
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/config"
+)
+
+const (
+	DriverJson = "json"
+	DriverBolt = "bolt"
+)
+
+// Changes lists the parts of the content touched by a mutation.
+// Meta covers everything but users and nodes, like settings and stats, and the total usage history.
+// The history of each user and node (usage, periods, ledger, and events) is committed with it.
+type Changes struct {
+	Meta         bool
+	Users        []*User
+	DeletedUsers []int
+	Nodes        []*Node
+	DeletedNodes []int
+}
+
+// Storage persists the database content.
+type Storage interface {
+	// Exists reports whether the storage holds any content yet.
+	Exists() bool
+	// Load reads the whole content into the given one, but the history.
+	// Content written before the history was stored apart has it in place.
+	Load(content *Content) error
+	// LoadHistory reads the stored history into the given one, replacing the records found.
+	LoadHistory(history *History) error
+	// Save replaces the whole stored content and its history (kept as it is for content without one).
+	Save(content *Content) error
+	// Commit persists only the given changes, when the storage supports it.
+	Commit(content *Content, changes *Changes) error
+	// Discard moves the stored content aside and returns its new path.
+	Discard() (string, error)
+	// Close releases the underlying resources.
+	Close() error
+}
+
+// NewStorage creates the storage of the given driver, sealing the sensitive fields with the given sealer.
+func NewStorage(driver string, env *config.Env, sealer *Sealer) (Storage, error) {
+	switch driver {
+	case DriverJson:
+		return newJsonStorage(env.DatabasePath, env.DatabaseHistoryPath, sealer), nil
+	case DriverBolt:
+		return newBoltStorage(env.DatabaseBoltPath, sealer), nil
+	default:
+		return nil, errors.Errorf("unknown database driver: %s", driver)
+	}
+}
+
+// Migrate copies the whole content and its history from one storage to another.
+func Migrate(from, to Storage) (*Content, error) {
+	content := newContent()
+	if err := from.Load(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := from.LoadHistory(content.History); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := to.Save(content); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return content, nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"github.com/miladrahimi/p-manager/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{"json to bolt", DriverJson, DriverBolt},
+		{"bolt to json", DriverBolt, DriverJson},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := config.NewEnv(t.TempDir())
+			if err := os.MkdirAll(filepath.Dir(env.DatabasePath), 0755); err != nil {
+				t.Fatal(err)
+			}
+			key, _ := GenerateKey()
+			sealer, err := NewSealer(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := NewStorage(tt.from, env, sealer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			to, err := NewStorage(tt.to, env, sealer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = from.Close()
+				_ = to.Close()
+			}()
+
+			d := New(nil, nil)
+			content := d.Content
+			content.Users = []*User{newTestUser(d, 1), newTestUser(d, 2)}
+			content.Nodes = []*Node{{Id: 1, Host: "10.0.0.2", HttpToken: "token", HttpPort: 8080}}
+			content.History.Users[2] = &Series{Daily: []*Point{{Time: 1, Bytes: 100}}}
+			if err = from.Save(content); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = Migrate(from, to); err != nil {
+				t.Fatal(err)
+			}
+
+			migrated := newContent()
+			if err = to.Load(migrated); err != nil {
+				t.Fatal(err)
+			}
+			if err = to.LoadHistory(migrated.History); err != nil {
+				t.Fatal(err)
+			}
+			if len(migrated.Users) != 2 || len(migrated.Nodes) != 1 {
+				t.Fatalf("migrated %d users and %d nodes, want 2 and 1", len(migrated.Users), len(migrated.Nodes))
+			}
+			if migrated.Users[1].ShadowsocksPassword != content.Users[1].ShadowsocksPassword {
+				t.Errorf("the password of the user is not migrated")
+			}
+			if migrated.Nodes[0].HttpToken != "token" {
+				t.Errorf("the token of the node is not migrated")
+			}
+			if migrated.Settings.SsServerKey != content.Settings.SsServerKey {
+				t.Errorf("the server key is not migrated")
+			}
+			if migrated.History.Users[2] == nil {
+				t.Errorf("the history is not migrated")
+			}
+		})
+	}
+}
@@ -1,15 +1,210 @@
 package database
 
+import (
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Quota policies define which traffic directions count against the quota.
+const (
+	QuotaPolicyTotal    = "total"
+	QuotaPolicyDownlink = "downlink"
+)
+
+// Disabled reasons tell why the application disabled a user; users disabled by the admin have none.
+const (
+	DisabledReasonQuota   = "quota"
+	DisabledReasonExpired = "expired"
+	DisabledReasonIps     = "ips"
+)
+
 type User struct {
-	Id                  int     `json:"id"`
-	Identity            string  `json:"identity" validate:"required"`
-	Name                string  `json:"name" validate:"required,min=1,max=64"`
-	Quota               float64 `json:"quota" validate:"min=0"`
-	Usage               float64 `json:"usage" validate:"min=0"`
-	UsageBytes          int64   `json:"usage_bytes" validate:"min=0"`
-	UsageResetAt        int64   `json:"usage_reset_at"`
-	Enabled             bool    `json:"enabled"`
-	ShadowsocksPassword string  `json:"shadowsocks_password" validate:"required,min=1,max=64"`
-	ShadowsocksMethod   string  `json:"shadowsocks_method" validate:"required"`
-	CreatedAt           int64   `json:"created_at"`
+	Id                  int           `json:"id"`
+	Identity            string        `json:"identity" validate:"required"`
+	Name                string        `json:"name" validate:"required,min=1,max=64"`
+	Quota               float64       `json:"quota" validate:"min=0"`
+	QuotaPolicy         string        `json:"quota_policy" validate:"omitempty,oneof=total downlink"`
+	Usage               float64       `json:"usage" validate:"min=0"`
+	UsageBytes          int64         `json:"usage_bytes" validate:"min=0"`
+	Uplink              float64       `json:"uplink" validate:"min=0"`
+	UplinkBytes         int64         `json:"uplink_bytes" validate:"min=0"`
+	Downlink            float64       `json:"downlink" validate:"min=0"`
+	DownlinkBytes       int64         `json:"downlink_bytes" validate:"min=0"`
+	UsageResetAt        int64         `json:"usage_reset_at"`
+	ResetPolicy         string        `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly anniversary days"`
+	ResetDays           int           `json:"reset_days" validate:"required_if=ResetPolicy days,min=0,max=3650"`
+	Enabled             bool          `json:"enabled"`
+	DisabledReason      string        `json:"disabled_reason"`
+	ExpiresAt           int64         `json:"expires_at" validate:"min=0"`
+	ShadowsocksPassword string        `json:"shadowsocks_password" validate:"required,min=1,max=64"`
+	ShadowsocksMethod   string        `json:"shadowsocks_method" validate:"required"`
+	CreatedAt           int64         `json:"created_at"`
+	PlanId              int           `json:"plan_id"`
+	Protocols           []string      `json:"protocols" validate:"dive,oneof=direct relay reverse"`
+	Tags                []string      `json:"tags" validate:"max=32,dive,required,max=32"`
+	QuotaWarning        int           `json:"quota_warning"`
+	Overage             float64       `json:"overage" validate:"min=0"`
+	OverageUnit         string        `json:"overage_unit" validate:"omitempty,oneof=percent gb"`
+	QuotaLimit          string        `json:"quota_limit" validate:"omitempty,oneof=hard soft"`
+	QuotaExceeded       bool          `json:"quota_exceeded"`
+	Credentials         []*Credential `json:"credentials" validate:"max=16,dive"`
+	LastCredentialId    int           `json:"last_credential_id"`
+	MaxIps              int           `json:"max_ips" validate:"min=0,max=1024"`
+	IpLimit             string        `json:"ip_limit" validate:"omitempty,oneof=disable flag"`
+	IpsExceeded         bool          `json:"ips_exceeded"`
+	DisabledUntil       int64         `json:"disabled_until"`
+	LastSeenAt          int64         `json:"last_seen_at"`
+	Online              bool          `json:"online"`
+	// The previous identity and password of the user keep working until the end of their grace periods after rotations.
+	PreviousIdentity      string `json:"previous_identity"`
+	PreviousIdentityUntil int64  `json:"previous_identity_until"`
+	PreviousPassword      string `json:"previous_password"`
+	PreviousPasswordUntil int64  `json:"previous_password_until"`
+}
+
+// UserSelector selects users for batch operations; empty fields select all the users.
+// Users must match every given field (the search is a part of the name), and any of the given IDs and tags.
+// Inactive users have not connected in the given number of days, or never.
+type UserSelector struct {
+	Search       string
+	Ids          []int
+	Tags         []string
+	Enabled      *bool
+	Expired      *bool
+	OverQuota    *bool
+	Online       *bool
+	InactiveDays int
+}
+
+// SelectUsers returns the users matching the selector at the given time.
+func (d *Database) SelectUsers(s *UserSelector, t time.Time) []*User {
+	users := []*User{}
+	search := strings.ToLower(s.Search)
+	for _, u := range d.Content.Users {
+		if search != "" && !strings.Contains(strings.ToLower(u.Name), search) {
+			continue
+		}
+		if len(s.Ids) > 0 && !slices.Contains(s.Ids, u.Id) {
+			continue
+		}
+		if len(s.Tags) > 0 && !slices.ContainsFunc(s.Tags, u.HasTag) {
+			continue
+		}
+		if s.Enabled != nil && u.Enabled != *s.Enabled {
+			continue
+		}
+		if s.Expired != nil && u.Expired(t) != *s.Expired {
+			continue
+		}
+		if s.OverQuota != nil && u.OverQuota(d.Content.Settings.QuotaPolicy) != *s.OverQuota {
+			continue
+		}
+		if s.Online != nil && u.Online != *s.Online {
+			continue
+		}
+		if s.InactiveDays > 0 && u.LastSeenAt > t.AddDate(0, 0, -s.InactiveDays).UnixMilli() {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// HasTag reports whether the user has the given tag.
+func (u *User) HasTag(tag string) bool {
+	return slices.Contains(u.Tags, tag)
+}
+
+// Allows reports whether the user can connect through the given protocol; users without protocols can use all.
+func (u *User) Allows(protocol string) bool {
+	return len(u.Protocols) == 0 || slices.Contains(u.Protocols, protocol)
+}
+
+// SetEnabled enables or disables the user by the admin.
+// The disabled reason is kept when the status does not change, like when other fields of the user are updated.
+func (u *User) SetEnabled(enabled bool) {
+	if u.Enabled != enabled {
+		u.Enabled = enabled
+		u.DisabledReason = ""
+	}
+}
+
+// Disable disables the user for the given reason.
+func (u *User) Disable(reason string) {
+	u.Enabled = false
+	u.DisabledReason = reason
+}
+
+// Enable enables the user and clears the disabled reason.
+func (u *User) Enable() {
+	u.Enabled = true
+	u.DisabledReason = ""
+}
+
+// Expired reports whether the user has an expiration date that has passed at the given time.
+func (u *User) Expired(t time.Time) bool {
+	return u.ExpiresAt > 0 && u.ExpiresAt <= t.UnixMilli()
+}
+
+// AddTraffic adds the bytes passed in each direction to the usage.
+func (u *User) AddTraffic(uplink, downlink int64) {
+	u.UplinkBytes += uplink
+	u.DownlinkBytes += downlink
+	u.UsageBytes += uplink + downlink
+	u.Uplink = utils.RoundFloat(float64(u.UplinkBytes)/1000/1000/1000, 2)
+	u.Downlink = utils.RoundFloat(float64(u.DownlinkBytes)/1000/1000/1000, 2)
+	u.Usage = utils.RoundFloat(float64(u.UsageBytes)/1000/1000/1000, 2)
+}
+
+// SetUsage sets the usage (GB) of the user and starts a new period of quota warnings and soft limits.
+// The direction of a usage set by hand is unknown, so it is counted as downlink, which every quota policy counts.
+// The usages of the credentials start over.
+func (u *User) SetUsage(usage float64) {
+	u.QuotaWarning = 0
+	u.QuotaExceeded = false
+	for _, c := range u.Credentials {
+		c.Usage = 0
+		c.UsageBytes = 0
+	}
+	u.Usage = usage
+	u.UsageBytes = int64(usage * 1000 * 1000 * 1000)
+	u.Uplink = 0
+	u.UplinkBytes = 0
+	u.Downlink = u.Usage
+	u.DownlinkBytes = u.UsageBytes
+}
+
+// AdjustUsage adds the bytes to the usage, or deducts them for negative values.
+// Added bytes are counted as downlink, and deducted bytes are taken from the downlink first, then the uplink.
+func (u *User) AdjustUsage(bytes int64) {
+	if bytes >= 0 {
+		u.AddTraffic(0, bytes)
+		return
+	}
+	downlink := min(-bytes, u.DownlinkBytes)
+	uplink := min(-bytes-downlink, u.UplinkBytes)
+	u.DownlinkBytes -= downlink
+	u.UplinkBytes -= uplink
+	u.UsageBytes = u.UplinkBytes + u.DownlinkBytes
+	u.AddTraffic(0, 0)
+}
+
+// QuotaUsage returns the usage (GB) counted against the quota.
+// The user's own quota policy applies, and the given default one applies when the user has none.
+func (u *User) QuotaUsage(defaultPolicy string) float64 {
+	policy := u.QuotaPolicy
+	if policy == "" {
+		policy = defaultPolicy
+	}
+	if policy == QuotaPolicyDownlink {
+		return u.Downlink
+	}
+	return u.Usage
+}
+
+// OverQuota reports whether the user has a quota and has used more than it.
+func (u *User) OverQuota(defaultPolicy string) bool {
+	return u.Quota > 0 && u.QuotaUsage(defaultPolicy) > u.Quota
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 func Profile(config *config.Config, d *database.Database) echo.HandlerFunc {
@@ -15,15 +16,13 @@ func Profile(config *config.Config, d *database.Database) echo.HandlerFunc {
 		c.Response().Header().Set("Pragma", "no-cache")
 		c.Response().Header().Set("Expires", "0")
 
-		userId := c.QueryParams().Get("u")
-		for _, u := range d.Content.Users {
-			if u.Identity == userId {
-				content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile.html"))
-				if err != nil {
-					return err
-				}
-				return c.HTML(http.StatusOK, string(content))
+		identity := c.QueryParams().Get("u")
+		if d.FindUserByIdentity(identity) != nil || d.FindUserByPreviousIdentity(identity, time.Now()) != nil {
+			content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile.html"))
+			if err != nil {
+				return err
 			}
+			return c.HTML(http.StatusOK, string(content))
 		}
 
 		content, err := os.ReadFile(filepath.Join(config.Env.AppDirectory, "web/profile-404.html"))
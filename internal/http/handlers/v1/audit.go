@@ -0,0 +1,83 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"net/http"
+	"strconv"
+)
+
+type AuditIndexResponse struct {
+	Total   int              `json:"total"`
+	Entries []*auditor.Entry `json:"entries"`
+}
+
+func AuditIndex(a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		f := &auditor.Filter{
+			Actor:  c.QueryParam("actor"),
+			Ip:     c.QueryParam("ip"),
+			Action: c.QueryParam("action"),
+			Target: c.QueryParam("target"),
+			Limit:  100,
+		}
+
+		for name, field := range map[string]*int64{"from": &f.From, "to": &f.To} {
+			if v := c.QueryParam(name); v != "" {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, map[string]string{
+						"message": fmt.Sprintf("Invalid query parameter: %s.", name),
+					})
+				}
+				*field = n
+			}
+		}
+		for name, field := range map[string]*int{"offset": &f.Offset, "limit": &f.Limit} {
+			if v := c.QueryParam(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return c.JSON(http.StatusBadRequest, map[string]string{
+						"message": fmt.Sprintf("Invalid query parameter: %s.", name),
+					})
+				}
+				*field = n
+			}
+		}
+
+		entries, total, err := a.Query(f)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if entries == nil {
+			entries = []*auditor.Entry{}
+		}
+
+		return c.JSON(http.StatusOK, AuditIndexResponse{Total: total, Entries: entries})
+	}
+}
+
+// record writes an audit entry of an admin action on the target.
+func record(a *auditor.Auditor, c echo.Context, action, target string, before, after interface{}) {
+	a.Record(&auditor.Entry{
+		Actor:   auditor.ActorAdmin,
+		Ip:      c.RealIP(),
+		Action:  action,
+		Target:  target,
+		Changes: auditor.Diff(before, after),
+	})
+}
+
+func userTarget(id int) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+func nodeTarget(id int) string {
+	return fmt.Sprintf("node:%d", id)
+}
+
+func planTarget(id int) string {
+	return fmt.Sprintf("plan:%d", id)
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"github.com/miladrahimi/p-manager/internal/coordinator"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/miladrahimi/p-manager/internal/licensor"
+	"io"
+	"net/http"
+)
+
+const maxBackupSize = 64 * 1024 * 1024
+
+func BackupsIndex(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		backups, err := d.Backups()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if backups == nil {
+			backups = []*database.BackupInfo{}
+		}
+		return c.JSON(http.StatusOK, backups)
+	}
+}
+
+func BackupsShow(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		path, found := d.BackupPath(c.Param("name"))
+		if !found {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Not found."})
+		}
+		return c.Attachment(path, c.Param("name"))
+	}
+}
+
+func BackupsStore(d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot find the backup file in the request.",
+			})
+		}
+		if fh.Size > maxBackupSize {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "The backup file is too large.",
+			})
+		}
+
+		file, err := fh.Open()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxBackupSize))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		backup, err := d.StoreBackup(data)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Invalid backup file: %v", err.Error()),
+			})
+		}
+
+		record(a, c, "backups.store", "backup:"+backup.Name, nil, backup)
+
+		return c.JSON(http.StatusCreated, backup)
+	}
+}
+
+func BackupsRestore(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	l *licensor.Licensor,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, found := d.BackupPath(c.Param("name")); !found {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Not found."})
+		}
+
+		content, err := d.ReadBackup(c.Param("name"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Invalid backup file: %v", err.Error()),
+			})
+		}
+
+		// The users of the backup replace the current ones, and they are limited like the created ones.
+		d.Locker.RLock()
+		message := checkUsersCount(d, l, len(content.Users)-len(d.Content.Users))
+		d.Locker.RUnlock()
+		if message != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": message})
+		}
+
+		err = coordinator.Pause(func() error {
+			d.Locker.Lock()
+			defer d.Locker.Unlock()
+
+			return d.Restore(content)
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "backups.restore", "backup:"+c.Param("name"), nil, nil)
+
+		return c.JSON(http.StatusOK, map[string]int{
+			"users": len(content.Users),
+			"nodes": len(content.Nodes),
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/miladrahimi/p-manager/internal/licensor"
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupsRestoreUsersCount(t *testing.T) {
+	t.Setenv(config.DatabaseKeyEnv, "")
+
+	env := config.NewEnv(t.TempDir())
+	env.DefaultConfigPath = filepath.Join("..", "..", "..", "..", "configs", "main.defaults.json")
+	for _, directory := range []string{"storage/app", "storage/database", "storage/logs"} {
+		if err := os.MkdirAll(filepath.Join(env.AppDirectory, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := config.New(env)
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	l := logger.New(c.Logger.Level, c.Logger.Format, make(chan struct{}))
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+	d := database.New(l, c)
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// The backup has more users than the unlicensed instances can have.
+	content := database.New(nil, nil).Content
+	for i := 1; i <= config.FreeUsersCount+1; i++ {
+		content.Users = append(content.Users, &database.User{
+			Id:                  i,
+			Identity:            fmt.Sprintf("identity-%d", i),
+			Name:                fmt.Sprintf("user-%d", i),
+			ShadowsocksMethod:   config.ShadowsocksMethod,
+			ShadowsocksPassword: fmt.Sprintf("password-%d", i),
+		})
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := d.StoreBackup(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	context := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), recorder)
+	context.SetParamNames("name")
+	context.SetParamValues(backup.Name)
+
+	if err = BackupsRestore(nil, d, licensor.New(c, nil, l, d, nil), nil)(context); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusBadRequest, recorder.Body.String())
+	}
+	if len(d.Content.Users) != 0 {
+		t.Errorf("the backup is restored with %d users", len(d.Content.Users))
+	}
+}
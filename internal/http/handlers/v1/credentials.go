@@ -0,0 +1,234 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"github.com/miladrahimi/p-manager/internal/coordinator"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type CredentialsStoreRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=32"`
+}
+
+func UsersCredentialsStore(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request CredentialsStoreRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var user *database.User
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				user = u
+			}
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		if len(user.Credentials) >= database.MaxCredentials {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"message": "The user has already reached the maximum number of credentials.",
+			})
+		}
+		for _, cr := range user.Credentials {
+			if cr.Name == request.Name {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "The name is already taken.",
+				})
+			}
+		}
+
+		before := *user
+
+		credential := &database.Credential{
+			Id:        user.GenerateCredentialId(),
+			Name:      request.Name,
+			Password:  d.GenerateUserPassword(user.ShadowsocksMethod),
+			CreatedAt: time.Now().UnixMilli(),
+		}
+		user.Credentials = append(slices.Clone(user.Credentials), credential)
+
+		if err := d.SaveUsers(user); err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "users.credentials.store", userTarget(user.Id), &before, user)
+
+		go coordinator.SyncConfigs()
+
+		return c.JSON(http.StatusCreated, credential)
+	}
+}
+
+func UsersCredentialsRegenerate(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return regenerateCredential(c, coordinator, d, a, u, auditor.ActorAdmin, "users.credentials.regenerate")
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func UsersCredentialsDelete(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return deleteCredential(c, coordinator, d, a, u, auditor.ActorAdmin, "users.credentials.delete")
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func ProfileCredentialsRegenerate(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		if u := d.FindUserByIdentity(c.QueryParam("u")); u != nil {
+			return regenerateCredential(c, coordinator, d, a, u, userTarget(u.Id), "profile.credentials.regenerate")
+		}
+
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "Not found.",
+		})
+	}
+}
+
+func ProfileCredentialsDelete(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		if u := d.FindUserByIdentity(c.QueryParam("u")); u != nil {
+			return deleteCredential(c, coordinator, d, a, u, userTarget(u.Id), "profile.credentials.delete")
+		}
+
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "Not found.",
+		})
+	}
+}
+
+// regenerateCredential generates a new password for the credential of the request, so its old links stop working.
+func regenerateCredential(
+	c echo.Context,
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+	user *database.User,
+	actor, action string,
+) error {
+	id, _ := strconv.Atoi(c.Param("credential"))
+	if user.FindCredential(id) == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"message": "Not found.",
+		})
+	}
+
+	before := *user
+	user.Credentials = user.CloneCredentials()
+
+	credential := user.FindCredential(id)
+	credential.Password = d.GenerateUserPassword(user.ShadowsocksMethod)
+
+	if err := d.SaveUsers(user); err != nil {
+		return errors.WithStack(err)
+	}
+
+	a.Record(&auditor.Entry{
+		Actor:   actor,
+		Ip:      c.RealIP(),
+		Action:  action,
+		Target:  userTarget(user.Id),
+		Changes: auditor.Diff(&before, user),
+	})
+
+	go coordinator.SyncConfigs()
+
+	return c.JSON(http.StatusOK, credential)
+}
+
+// deleteCredential revokes the credential of the request, like the credential of a lost device.
+func deleteCredential(
+	c echo.Context,
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+	user *database.User,
+	actor, action string,
+) error {
+	for i, cr := range user.Credentials {
+		if strconv.Itoa(cr.Id) == c.Param("credential") {
+			before := *user
+			user.Credentials = slices.Delete(slices.Clone(user.Credentials), i, i+1)
+
+			if err := d.SaveUsers(user); err != nil {
+				return errors.WithStack(err)
+			}
+
+			a.Record(&auditor.Entry{
+				Actor:   actor,
+				Ip:      c.RealIP(),
+				Action:  action,
+				Target:  userTarget(user.Id),
+				Changes: auditor.Diff(&before, user),
+			})
+
+			go coordinator.SyncConfigs()
+			break
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
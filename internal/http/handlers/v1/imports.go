@@ -6,10 +6,12 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"github.com/miladrahimi/p-manager/internal/http/client"
 	"net/http"
 	"slices"
+	"time"
 )
 
 type SettingsImportPManagerRequest struct {
@@ -17,7 +19,7 @@ type SettingsImportPManagerRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-func ImportsStore(d *database.Database, hc *client.Client) echo.HandlerFunc {
+func ImportsStore(d *database.Database, hc *client.Client, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var r SettingsImportPManagerRequest
 		if err := c.Bind(&r); err != nil {
@@ -55,6 +57,7 @@ func ImportsStore(d *database.Database, hc *client.Client) echo.HandlerFunc {
 			names = append(names, u.Name)
 		}
 
+		var imported []*database.User
 		var results []string
 		for i, u := range users {
 			if slices.Index(names, u.Name) != -1 {
@@ -62,14 +65,29 @@ func ImportsStore(d *database.Database, hc *client.Client) echo.HandlerFunc {
 				continue
 			}
 			u.Id = d.GenerateUserId()
+			// The plans of the remote instance are not imported, and its ids may refer to other plans here.
+			u.PlanId = 0
+			if u.UsageResetAt == 0 {
+				u.UsageResetAt = time.Now().UnixMilli()
+			}
+			u.UsageResetAt = database.Millis(u.UsageResetAt)
+			if u.UplinkBytes+u.DownlinkBytes != u.UsageBytes {
+				u.Uplink, u.UplinkBytes = 0, 0
+				u.Downlink, u.DownlinkBytes = u.Usage, u.UsageBytes
+			}
 			d.Content.Users = append(d.Content.Users, &u)
+			imported = append(imported, &u)
 			results = append(results, fmt.Sprintf("Imported #%d: ID=%d Name=%s", users[i].Id, u.Id, u.Name))
 		}
 
-		if err = d.Save(); err != nil {
+		if err = d.SaveUsers(imported...); err != nil {
 			return errors.WithStack(err)
 		}
 
+		for _, u := range imported {
+			record(a, c, "users.import", userTarget(u.Id), nil, u)
+		}
+
 		return c.JSON(http.StatusOK, results)
 	}
 }
@@ -0,0 +1,142 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"github.com/miladrahimi/p-manager/internal/coordinator"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type UsersTopupsStoreRequest struct {
+	Amount float64 `json:"amount" validate:"required,gt=0,lte=1000000"`
+	Reason string  `json:"reason" validate:"max=256"`
+}
+
+type UsersAdjustmentsStoreRequest struct {
+	Amount float64 `json:"amount" validate:"required,gte=-1000000,lte=1000000"`
+	Reason string  `json:"reason" validate:"max=256"`
+}
+
+type UsersLedgerStoreResponse struct {
+	Entry *database.LedgerEntry `json:"entry"`
+	User  *database.User        `json:"user"`
+}
+
+func UsersLedger(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return c.JSON(http.StatusOK, d.UserLedger(u.Id))
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func UsersTopupsStore(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request UsersTopupsStoreRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		// Unlimited users have no quota to add to, and a top-up would limit them to its amount.
+		check := func(u *database.User) string {
+			if u.Quota == 0 {
+				return "The user has no quota to top up."
+			}
+			return ""
+		}
+		return storeLedgerEntry(c, coordinator, d, a, "users.topup", check, func(u *database.User) *database.LedgerEntry {
+			return d.Topup(u, request.Amount, request.Reason, auditor.ActorAdmin, time.Now())
+		})
+	}
+}
+
+func UsersAdjustmentsStore(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request UsersAdjustmentsStoreRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		return storeLedgerEntry(c, coordinator, d, a, "users.adjust_usage", nil, func(u *database.User) *database.LedgerEntry {
+			return d.AdjustUsage(u, request.Amount, request.Reason, auditor.ActorAdmin, time.Now())
+		})
+	}
+}
+
+// storeLedgerEntry applies the change on the user of the request, if the check (optional) has no objection,
+// and saves it with its ledger entry.
+// Users disabled (and not expired) or flagged over quota are enabled or unflagged when the change brings them within
+// their allowance, and the quota warnings they no longer reach are cleared.
+func storeLedgerEntry(
+	c echo.Context,
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+	action string,
+	check func(u *database.User) string,
+	change func(u *database.User) *database.LedgerEntry,
+) error {
+	d.Locker.Lock()
+	defer d.Locker.Unlock()
+
+	var user *database.User
+	for _, u := range d.Content.Users {
+		if strconv.Itoa(u.Id) == c.Param("id") {
+			user = u
+		}
+	}
+	if user == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if check != nil {
+		if message := check(user); message != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": message})
+		}
+	}
+
+	before := *user
+
+	entry := change(user)
+	d.ReleaseQuota(user, time.Now())
+
+	if err := d.Commit(&database.Changes{Users: []*database.User{user}}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	record(a, c, action, userTarget(user.Id), &before, user)
+
+	go coordinator.SyncConfigs()
+
+	return c.JSON(http.StatusCreated, UsersLedgerStoreResponse{Entry: entry, User: user})
+}
@@ -0,0 +1,33 @@
+package v1
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsersTopupsStoreUnlimited(t *testing.T) {
+	d := database.New(nil, nil)
+	u := &database.User{Id: 1, Name: "user", Enabled: true}
+	d.Content.Users = []*database.User{u}
+
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount":10}`))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder := httptest.NewRecorder()
+	context := echo.New().NewContext(request, recorder)
+	context.SetParamNames("id")
+	context.SetParamValues("1")
+
+	if err := UsersTopupsStore(nil, d, nil)(context); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	if u.Quota != 0 || len(d.UserLedger(1)) != 0 {
+		t.Errorf("the unlimited user is topped up: quota = %v", u.Quota)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"net/http"
@@ -31,7 +32,63 @@ func NodesIndex(d *database.Database) echo.HandlerFunc {
 	}
 }
 
-func NodesStore(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func NodesUsageIndex(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		q, err := parseUsageQuery(c)
+		if q == nil {
+			return err
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		r := NodesUsageResponse{Granularity: q.Granularity, Nodes: []*NodeUsageResponse{}}
+		for _, node := range d.Content.Nodes {
+			points, err := d.NodeUsage(node.Id, q.Granularity, q.From, q.To)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			r.Nodes = append(r.Nodes, &NodeUsageResponse{
+				Id:     node.Id,
+				Host:   node.Host,
+				Points: makeUsageResponse(q, points, 1).Points,
+			})
+		}
+
+		return c.JSON(http.StatusOK, r)
+	}
+}
+
+func NodesUsage(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		q, err := parseUsageQuery(c)
+		if q == nil {
+			return err
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var node *database.Node
+		for _, n := range d.Content.Nodes {
+			if strconv.Itoa(n.Id) == c.Param("id") {
+				node = n
+			}
+		}
+		if node == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		points, err := d.NodeUsage(node.Id, q.Granularity, q.From, q.To)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return c.JSON(http.StatusOK, makeUsageResponse(q, points, 1))
+	}
+}
+
+func NodesStore(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var r NodesStoreRequest
 		if err := c.Bind(&r); err != nil {
@@ -64,17 +121,19 @@ func NodesStore(coordinator *coordinator.Coordinator, d *database.Database) echo
 
 		d.Content.Nodes = append(d.Content.Nodes, node)
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveNodes(node); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "nodes.store", nodeTarget(node.Id), nil, node)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusCreated, node)
 	}
 }
 
-func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var r NodesUpdateRequest
 		if err := c.Bind(&r); err != nil {
@@ -101,14 +160,18 @@ func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 			return c.JSON(http.StatusNotFound, map[string]string{"message": "Not found."})
 		}
 
+		before := *node
+
 		node.Host = r.Host
 		node.HttpToken = r.HttpToken
 		node.HttpPort = r.HttpPort
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveNodes(node); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "nodes.update", nodeTarget(node.Id), &before, node)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusOK, node)
@@ -116,7 +179,11 @@ func NodesUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 	}
 }
 
-func NodesUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func NodesUpdatePartialBatch(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request NodesUpdatePartialRequest
 		if err := c.Bind(&request); err != nil {
@@ -133,23 +200,30 @@ func NodesUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.D
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
+		befores := map[int]database.Node{}
 		for _, node := range d.Content.Nodes {
+			befores[node.Id] = *node
 			if request.Usage != nil {
-				node.Usage = *request.Usage
+				node.SetUsage(*request.Usage)
 			}
 		}
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveNodes(d.Content.Nodes...); err != nil {
 			return errors.WithStack(err)
 		}
 
+		for _, node := range d.Content.Nodes {
+			before := befores[node.Id]
+			record(a, c, "nodes.update_batch", nodeTarget(node.Id), &before, node)
+		}
+
 		go coordinator.SyncConfigs()
 
 		return c.NoContent(http.StatusNoContent)
 	}
 }
 
-func NodesDelete(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func NodesDelete(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
@@ -157,9 +231,10 @@ func NodesDelete(coordinator *coordinator.Coordinator, d *database.Database) ech
 		for i, s := range d.Content.Nodes {
 			if strconv.Itoa(s.Id) == c.Param("id") {
 				d.Content.Nodes = append(d.Content.Nodes[:i], d.Content.Nodes[i+1:]...)
-				if err := d.Save(); err != nil {
+				if err := d.DeleteNodes(s.Id); err != nil {
 					return errors.WithStack(err)
 				}
+				record(a, c, "nodes.delete", nodeTarget(s.Id), s, nil)
 				go coordinator.SyncConfigs()
 				break
 			}
@@ -0,0 +1,187 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"github.com/miladrahimi/p-manager/internal/coordinator"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type PlansStoreRequest struct {
+	Name        string   `json:"name" validate:"required,min=1,max=64"`
+	Quota       float64  `json:"quota" validate:"min=0"`
+	Duration    int      `json:"duration" validate:"min=0,max=3650"`
+	ResetPolicy string   `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly anniversary days"`
+	ResetDays   int      `json:"reset_days" validate:"required_if=ResetPolicy days,min=0,max=3650"`
+	Protocols   []string `json:"protocols" validate:"dive,oneof=direct relay reverse"`
+}
+
+type PlansUpdateRequest struct {
+	PlansStoreRequest
+	// ApplyToUsers applies the new limits of the plan on its current subscribers.
+	ApplyToUsers bool `json:"apply_to_users"`
+}
+
+type PlansUpdateResponse struct {
+	Plan  *database.Plan `json:"plan"`
+	Users int            `json:"users"`
+}
+
+func PlansIndex(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, d.Content.Plans)
+	}
+}
+
+func PlansStore(d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request PlansStoreRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		for _, p := range d.Content.Plans {
+			if p.Name == request.Name {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "The name is already taken.",
+				})
+			}
+		}
+
+		plan := &database.Plan{}
+		plan.Id = d.GeneratePlanId()
+		plan.CreatedAt = time.Now().UnixMilli()
+		fillPlan(plan, &request)
+
+		d.Content.Plans = append(d.Content.Plans, plan)
+
+		if err := d.SaveMeta(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "plans.store", planTarget(plan.Id), nil, plan)
+
+		return c.JSON(http.StatusCreated, plan)
+	}
+}
+
+func PlansUpdate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request PlansUpdateRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var plan *database.Plan
+		for _, p := range d.Content.Plans {
+			if strconv.Itoa(p.Id) == c.Param("id") {
+				plan = p
+			} else if p.Name == request.Name {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "The name is already taken.",
+				})
+			}
+		}
+		if plan == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		before := *plan
+		fillPlan(plan, &request.PlansStoreRequest)
+
+		changes := &database.Changes{Meta: true}
+		befores := map[int]database.User{}
+		if request.ApplyToUsers {
+			now := time.Now()
+			for _, u := range d.Content.Users {
+				if u.PlanId == plan.Id {
+					befores[u.Id] = *u
+					d.ApplyPlan(plan, u, now)
+					changes.Users = append(changes.Users, u)
+				}
+			}
+		}
+
+		if err := d.Commit(changes); err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "plans.update", planTarget(plan.Id), &before, plan)
+		for _, u := range changes.Users {
+			before := befores[u.Id]
+			record(a, c, "users.apply_plan", userTarget(u.Id), &before, u)
+		}
+
+		if len(changes.Users) > 0 {
+			go coordinator.SyncConfigs()
+		}
+
+		return c.JSON(http.StatusOK, PlansUpdateResponse{Plan: plan, Users: len(changes.Users)})
+	}
+}
+
+func PlansDelete(d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		for i, p := range d.Content.Plans {
+			if strconv.Itoa(p.Id) == c.Param("id") {
+				d.Content.Plans = slices.Delete(d.Content.Plans, i, i+1)
+
+				// The subscribers keep the limits of the plan.
+				changes := &database.Changes{Meta: true}
+				for _, u := range d.Content.Users {
+					if u.PlanId == p.Id {
+						u.PlanId = 0
+						changes.Users = append(changes.Users, u)
+					}
+				}
+
+				if err := d.Commit(changes); err != nil {
+					return errors.WithStack(err)
+				}
+				record(a, c, "plans.delete", planTarget(p.Id), p, nil)
+				break
+			}
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func fillPlan(plan *database.Plan, request *PlansStoreRequest) {
+	plan.Name = request.Name
+	plan.Quota = request.Quota
+	plan.Duration = request.Duration
+	plan.ResetPolicy = request.ResetPolicy
+	plan.ResetDays = request.ResetDays
+	plan.Protocols = normalizeValues(request.Protocols)
+}
@@ -1,28 +1,46 @@
 package v1
 
 import (
-	"encoding/base64"
 	"fmt"
 	"github.com/cockroachdb/errors"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"net/http"
+	"time"
 )
 
 type ProfileResponse struct {
-	User      database.User `json:"user"`
-	SsReverse string        `json:"ss_reverse"`
-	SsRelay   string        `json:"ss_relay"`
-	SsDirect  string        `json:"ss_direct"`
+	User       database.User `json:"user"`
+	QuotaUsage float64       `json:"quota_usage"`
+	ExpiresAt  int64         `json:"expires_at"`
+	Expired    bool          `json:"expired"`
+	SsReverse  string        `json:"ss_reverse"`
+	SsRelay    string        `json:"ss_relay"`
+	SsDirect   string        `json:"ss_direct"`
+	// Banner warns the user about the quota, empty when there is nothing to warn about.
+	Banner      string               `json:"banner"`
+	Credentials []*ProfileCredential `json:"credentials"`
+}
+
+// ProfileCredential is a credential of the user (for one of its devices) with its links.
+type ProfileCredential struct {
+	Id        int     `json:"id"`
+	Name      string  `json:"name"`
+	Usage     float64 `json:"usage"`
+	SsReverse string  `json:"ss_reverse"`
+	SsRelay   string  `json:"ss_relay"`
+	SsDirect  string  `json:"ss_direct"`
 }
 
 func ProfileShow(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		var user *database.User
-		for _, u := range d.Content.Users {
-			if u.Identity == c.QueryParam("u") {
-				user = u
+		// The previous identity shows the user as it was before the rotation, never the new secrets.
+		user, previous := d.FindUserByIdentity(c.QueryParam("u")), false
+		if user == nil {
+			if u := d.FindUserByPreviousIdentity(c.QueryParam("u"), time.Now()); u != nil {
+				user, previous = u.PreviousView(time.Now()), true
 			}
 		}
 		if user == nil {
@@ -33,37 +51,54 @@ func ProfileShow(d *database.Database) echo.HandlerFunc {
 
 		r := ProfileResponse{User: *user}
 		r.User.Usage = r.User.Usage * d.Content.Settings.TrafficRatio
+		r.User.Uplink = r.User.Uplink * d.Content.Settings.TrafficRatio
+		r.User.Downlink = r.User.Downlink * d.Content.Settings.TrafficRatio
+		r.QuotaUsage = user.QuotaUsage(d.Content.Settings.QuotaPolicy) * d.Content.Settings.TrafficRatio
+		r.ExpiresAt = user.ExpiresAt
+		r.Expired = user.Expired(time.Now())
 		r.User.Quota = r.User.Quota * d.Content.Settings.TrafficRatio
 
-		s := d.Content.Settings
-		auth := base64.StdEncoding.EncodeToString([]byte(user.ShadowsocksMethod + ":" + user.ShadowsocksPassword))
-
-		if s.SsReversePort > 0 {
-			r.SsReverse = fmt.Sprintf("ss://%s@%s:%d#%s", auth, s.Host, s.SsReversePort, "reverse")
+		if !user.Enabled && user.DisabledReason == database.DisabledReasonQuota {
+			r.Banner = "You have used up your quota."
+		} else if user.QuotaWarning > 0 {
+			r.Banner = fmt.Sprintf("You have used %d%% of your quota.", user.QuotaWarning)
 		}
 
-		if s.SsRelayPort > 0 {
-			r.SsRelay = fmt.Sprintf("ss://%s@%s:%d#%s", auth, s.Host, s.SsRelayPort, "relay")
+		if user.ShadowsocksPassword != "" {
+			r.SsReverse, r.SsRelay, r.SsDirect = profileLinks(d, user, nil)
 		}
 
-		if s.SsDirectPort > 0 {
-			r.SsDirect = fmt.Sprintf("ss://%s@%s:%d#%s", auth, s.Host, s.SsDirectPort, "direct")
+		r.User.Credentials = nil
+		r.User.PreviousIdentity, r.User.PreviousPassword = "", ""
+
+		// The previous identity works during its grace period only, so the user must move to the new one.
+		if previous {
+			r.Banner = "Your profile link has changed, and this one will stop working soon. Please ask for the new one."
+		}
+		r.Credentials = []*ProfileCredential{}
+		for _, cr := range user.Credentials {
+			pc := &ProfileCredential{Id: cr.Id, Name: cr.Name, Usage: cr.Usage * d.Content.Settings.TrafficRatio}
+			pc.SsReverse, pc.SsRelay, pc.SsDirect = profileLinks(d, user, cr)
+			r.Credentials = append(r.Credentials, pc)
 		}
 
 		return c.JSON(http.StatusOK, r)
 	}
 }
 
-func ProfileRegenerate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func ProfileUsage(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		q, err := parseUsageQuery(c)
+		if q == nil {
+			return err
+		}
+
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var user *database.User
-		for _, u := range d.Content.Users {
-			if u.Identity == c.QueryParam("u") {
-				user = u
-			}
+		user := d.FindUserByIdentity(c.QueryParam("u"))
+		if user == nil {
+			user = d.FindUserByPreviousIdentity(c.QueryParam("u"), time.Now())
 		}
 		if user == nil {
 			return c.JSON(http.StatusNotFound, map[string]string{
@@ -71,14 +106,64 @@ func ProfileRegenerate(coordinator *coordinator.Coordinator, d *database.Databas
 			})
 		}
 
-		user.ShadowsocksPassword = d.GenerateUserPassword()
+		points, err := d.UserUsage(user.Id, q.Granularity, q.From, q.To)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 
-		if err := d.Save(); err != nil {
+		return c.JSON(http.StatusOK, makeUsageResponse(q, points, d.Content.Settings.TrafficRatio))
+	}
+}
+
+func ProfileRegenerate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		user := d.FindUserByIdentity(c.QueryParam("u"))
+		if user == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"message": "Not found.",
+			})
+		}
+
+		before := *user
+
+		user.ShadowsocksPassword = d.GenerateUserPassword(user.ShadowsocksMethod)
+
+		if err := d.SaveUsers(user); err != nil {
 			return errors.WithStack(err)
 		}
 
+		a.Record(&auditor.Entry{
+			Actor:   userTarget(user.Id),
+			Ip:      c.RealIP(),
+			Action:  "profile.regenerate",
+			Target:  userTarget(user.Id),
+			Changes: auditor.Diff(&before, user),
+		})
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusOK, user)
 	}
 }
+
+// profileLinks returns the reverse, relay, and direct links of the credential of the user (nil for the main password),
+// empty for the protocols the user cannot connect through.
+func profileLinks(d *database.Database, user *database.User, cr *database.Credential) (string, string, string) {
+	s := d.Content.Settings
+
+	var reverse, relay, direct string
+	if s.SsReversePort > 0 && d.Joins(user, database.ProtocolReverse) {
+		reverse = d.ShadowsocksLink(user, cr, database.ProtocolReverse, s.SsReversePort)
+	}
+	if s.SsRelayPort > 0 && d.Joins(user, database.ProtocolRelay) {
+		relay = d.ShadowsocksLink(user, cr, database.ProtocolRelay, s.SsRelayPort)
+	}
+	if s.SsDirectPort > 0 && d.Joins(user, database.ProtocolDirect) {
+		direct = d.ShadowsocksLink(user, cr, database.ProtocolDirect, s.SsDirectPort)
+	}
+
+	return reverse, relay, direct
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRotatedDatabase returns a database with a user whose identity and password are rotated with a grace period.
+func newRotatedDatabase(t *testing.T) (*database.Database, *database.User) {
+	t.Helper()
+
+	d := database.New(nil, nil)
+	d.Content.Settings.SsDirectPort = 1080
+
+	u := &database.User{
+		Id:                  1,
+		Name:                "user",
+		Identity:            "old-identity",
+		ShadowsocksMethod:   "chacha20-ietf-poly1305",
+		ShadowsocksPassword: "old-password",
+		Enabled:             true,
+		Credentials:         []*database.Credential{{Id: 1, Name: "phone", Password: "phone-password"}},
+	}
+	d.Content.Users = []*database.User{u}
+
+	until := time.Now().Add(time.Hour).UnixMilli()
+	d.RotateIdentity(u, until)
+	d.RotatePassword(u, until)
+
+	return d, u
+}
+
+func serve(h echo.HandlerFunc, method, identity string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/?u="+identity, nil)
+	recorder := httptest.NewRecorder()
+	_ = h(echo.New().NewContext(request, recorder))
+	return recorder
+}
+
+func TestProfileShow(t *testing.T) {
+	d, u := newRotatedDatabase(t)
+	newIdentity, newPassword := u.Identity, u.ShadowsocksPassword
+
+	tests := []struct {
+		name     string
+		identity string
+		status   int
+		password string
+		leaks    []string
+	}{
+		{"current identity", newIdentity, http.StatusOK, newPassword, nil},
+		{"previous identity", "old-identity", http.StatusOK, "old-password", []string{newIdentity, newPassword, "phone-password"}},
+		{"unknown identity", "unknown", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(ProfileShow(d), http.MethodGet, tt.identity)
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			for _, secret := range tt.leaks {
+				if strings.Contains(recorder.Body.String(), secret) {
+					t.Errorf("response leaks %q: %s", secret, recorder.Body.String())
+				}
+			}
+
+			var r ProfileResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.User.ShadowsocksPassword != tt.password {
+				t.Errorf("password = %q, want %q", r.User.ShadowsocksPassword, tt.password)
+			}
+			if r.SsDirect == "" {
+				t.Errorf("direct link is missing")
+			}
+		})
+	}
+}
+
+func TestProfileShowPreviousIdentityWithoutPasswordGrace(t *testing.T) {
+	d, u := newRotatedDatabase(t)
+	u.PreviousPassword, u.PreviousPasswordUntil = "", 0
+
+	recorder := serve(ProfileShow(d), http.MethodGet, "old-identity")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if strings.Contains(recorder.Body.String(), u.ShadowsocksPassword) {
+		t.Errorf("response leaks the new password: %s", recorder.Body.String())
+	}
+
+	var r ProfileResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.SsDirect != "" || r.SsRelay != "" || r.SsReverse != "" {
+		t.Errorf("links are given without a previous password")
+	}
+}
+
+func TestProfileMutationsRejectPreviousIdentity(t *testing.T) {
+	d, u := newRotatedDatabase(t)
+	password, credential := u.ShadowsocksPassword, u.Credentials[0].Password
+
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+		method  string
+	}{
+		{"regenerate", ProfileRegenerate(nil, d, nil), http.MethodPost},
+		{"credential regenerate", ProfileCredentialsRegenerate(nil, d, nil), http.MethodPost},
+		{"credential delete", ProfileCredentialsDelete(nil, d, nil), http.MethodDelete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(tt.handler, tt.method, "old-identity")
+			if recorder.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+			}
+			if u.ShadowsocksPassword != password || len(u.Credentials) != 1 || u.Credentials[0].Password != credential {
+				t.Errorf("the user is changed through the previous identity")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
+	"github.com/miladrahimi/p-manager/internal/coordinator"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type UsersRotateRequest struct {
+	Identity bool `json:"identity" validate:"required_without=Password"`
+	Password bool `json:"password" validate:"required_without=Identity"`
+	// Grace is the number of minutes the previous identity and password keep working, zero for none.
+	Grace int `json:"grace" validate:"min=0,max=43200"`
+}
+
+func UsersRotate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request UsersRotateRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var user *database.User
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				user = u
+			}
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		before := *user
+
+		now := time.Now()
+		var until int64
+		if request.Grace > 0 {
+			until = now.Add(time.Duration(request.Grace) * time.Minute).UnixMilli()
+		}
+
+		var rotated []string
+		if request.Identity {
+			d.RotateIdentity(user, until)
+			rotated = append(rotated, "identity")
+		}
+		if request.Password {
+			d.RotatePassword(user, until)
+			rotated = append(rotated, "password")
+		}
+
+		d.RecordUserEvent(user.Id, &database.Event{
+			Type:    database.EventRotated,
+			Message: fmt.Sprintf("The %s has been rotated (grace period: %d minutes).", strings.Join(rotated, " and "), request.Grace),
+			Time:    now.UnixMilli(),
+		})
+
+		if err := d.Commit(&database.Changes{Users: []*database.User{user}}); err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "users.rotate", userTarget(user.Id), &before, user)
+
+		if request.Password {
+			go coordinator.SyncConfigs()
+		}
+
+		return c.JSON(http.StatusOK, user)
+	}
+}
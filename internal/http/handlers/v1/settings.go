@@ -5,6 +5,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"github.com/miladrahimi/p-manager/internal/utils"
@@ -17,7 +18,7 @@ func SettingsShow(d *database.Database) echo.HandlerFunc {
 	}
 }
 
-func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var r database.Settings
 		if err := c.Bind(&r); err != nil {
@@ -41,6 +42,7 @@ func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database)
 		defer d.Locker.Unlock()
 
 		current := d.Content.Settings
+		r.SsServerKey = current.SsServerKey
 		if r.SsRelayPort > 0 && r.SsRelayPort != current.SsRelayPort && !utils.PortFree(r.SsRelayPort) {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"message": fmt.Sprintf("Port %d is already in use.", r.SsRelayPort),
@@ -59,18 +61,21 @@ func SettingsUpdate(coordinator *coordinator.Coordinator, d *database.Database)
 
 		d.Content.Settings = &r
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveMeta(); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "settings.update", "settings", current, &r)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusOK, r)
 	}
 }
 
-func SettingsXrayRestart(coordinator *coordinator.Coordinator) echo.HandlerFunc {
+func SettingsXrayRestart(coordinator *coordinator.Coordinator, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		record(a, c, "settings.xray_restart", "xray", nil, nil)
 		go coordinator.SyncConfigs()
 		return c.NoContent(http.StatusNoContent)
 	}
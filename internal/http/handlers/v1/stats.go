@@ -5,6 +5,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"net/http"
 	"time"
@@ -17,16 +18,22 @@ type StatsUpdatePartialRequest struct {
 type StatsResponse struct {
 	TotalUsageResetAt int64   `json:"total_usage_reset_at"`
 	TotalUsage        float64 `json:"total_usage"`
+	TotalUplink       float64 `json:"total_uplink"`
+	TotalDownlink     float64 `json:"total_downlink"`
 	TotalUsers        int     `json:"total_users"`
 	ActiveUsers       int     `json:"active_users"`
+	OnlineUsers       int     `json:"online_users"`
 }
 
 func makeStatsResponse(d *database.Database) *StatsResponse {
 	return &StatsResponse{
 		TotalUsageResetAt: d.Content.Stats.TotalUsageResetAt,
 		TotalUsage:        d.Content.Stats.TotalUsage,
+		TotalUplink:       d.Content.Stats.TotalUplink,
+		TotalDownlink:     d.Content.Stats.TotalDownlink,
 		TotalUsers:        len(d.Content.Users),
 		ActiveUsers:       d.CountActiveUsers(),
+		OnlineUsers:       d.CountOnlineUsers(),
 	}
 }
 
@@ -36,7 +43,26 @@ func StatsIndex(d *database.Database) echo.HandlerFunc {
 	}
 }
 
-func StatsUpdatePartial(d *database.Database) echo.HandlerFunc {
+func StatsUsage(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		q, err := parseUsageQuery(c)
+		if q == nil {
+			return err
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		points, err := d.TotalUsage(q.Granularity, q.From, q.To)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return c.JSON(http.StatusOK, makeUsageResponse(q, points, 1))
+	}
+}
+
+func StatsUpdatePartial(d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request StatsUpdatePartialRequest
 		if err := c.Bind(&request); err != nil {
@@ -53,15 +79,21 @@ func StatsUpdatePartial(d *database.Database) echo.HandlerFunc {
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
+		before := *d.Content.Stats
+
 		if request.TotalUsage != nil {
 			d.Content.Stats.TotalUsage = *request.TotalUsage
+			d.Content.Stats.TotalUplink = 0
+			d.Content.Stats.TotalDownlink = *request.TotalUsage
 			d.Content.Stats.TotalUsageResetAt = time.Now().UnixMilli()
 		}
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveMeta(); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "stats.update", "stats", &before, d.Content.Stats)
+
 		return c.JSON(http.StatusOK, makeStatsResponse(d))
 	}
 }
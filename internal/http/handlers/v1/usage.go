@@ -0,0 +1,88 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/miladrahimi/p-manager/internal/utils"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+type UsagePoint struct {
+	Time  int64   `json:"time"`
+	Bytes int64   `json:"bytes"`
+	Usage float64 `json:"usage"`
+}
+
+type UsageResponse struct {
+	Granularity string        `json:"granularity"`
+	Points      []*UsagePoint `json:"points"`
+}
+
+type NodeUsageResponse struct {
+	Id     int           `json:"id"`
+	Host   string        `json:"host"`
+	Points []*UsagePoint `json:"points"`
+}
+
+type NodesUsageResponse struct {
+	Granularity string               `json:"granularity"`
+	Nodes       []*NodeUsageResponse `json:"nodes"`
+}
+
+var granularities = []string{
+	database.GranularityFiveMinutes,
+	database.GranularityHourly,
+	database.GranularityDaily,
+}
+
+// UsageQuery is the time range and granularity of a requested usage history.
+type UsageQuery struct {
+	Granularity string
+	From        int64
+	To          int64
+}
+
+// parseUsageQuery reads the usage history query parameters, the daily granularity by default.
+// It writes the error response itself and returns nil when the parameters are invalid.
+func parseUsageQuery(c echo.Context) (*UsageQuery, error) {
+	q := &UsageQuery{Granularity: c.QueryParam("granularity")}
+	if q.Granularity == "" {
+		q.Granularity = database.GranularityDaily
+	}
+	if !slices.Contains(granularities, q.Granularity) {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "Invalid query parameter: granularity.",
+		})
+	}
+
+	for name, field := range map[string]*int64{"from": &q.From, "to": &q.To} {
+		if v := c.QueryParam(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, c.JSON(http.StatusBadRequest, map[string]string{
+					"message": fmt.Sprintf("Invalid query parameter: %s.", name),
+				})
+			}
+			*field = n
+		}
+	}
+
+	return q, nil
+}
+
+// makeUsageResponse converts the points into the response, scaling the traffic by the given ratio.
+func makeUsageResponse(q *UsageQuery, points []*database.Point, ratio float64) *UsageResponse {
+	r := &UsageResponse{Granularity: q.Granularity, Points: []*UsagePoint{}}
+	for _, p := range points {
+		bytes := int64(float64(p.Bytes) * ratio)
+		r.Points = append(r.Points, &UsagePoint{
+			Time:  p.Time,
+			Bytes: bytes,
+			Usage: utils.RoundFloat(float64(bytes)/1000/1000/1000, 2),
+		})
+	}
+	return r
+}
@@ -1,43 +1,240 @@
 package v1
 
 import (
+	"cmp"
 	"fmt"
 	"github.com/cockroachdb/errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/config"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
 	"github.com/miladrahimi/p-manager/internal/licensor"
+	"github.com/miladrahimi/p-manager/internal/tracker"
 	"net/http"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type UsersStoreRequest struct {
-	Name    string  `json:"name" validate:"required,min=1,max=32"`
-	Enabled bool    `json:"enabled"`
-	Quota   float64 `json:"quota" validate:"min=0"`
-	Usage   float64 `json:"usage"`
+	Name        string   `json:"name" validate:"required,min=1,max=32"`
+	Enabled     bool     `json:"enabled"`
+	Quota       float64  `json:"quota" validate:"min=0"`
+	QuotaPolicy string   `json:"quota_policy" validate:"omitempty,oneof=total downlink"`
+	Usage       float64  `json:"usage"`
+	ExpiresAt   int64    `json:"expires_at" validate:"min=0"`
+	ResetPolicy string   `json:"reset_policy" validate:"omitempty,oneof=none daily weekly monthly anniversary days"`
+	ResetDays   int      `json:"reset_days" validate:"required_if=ResetPolicy days,min=0,max=3650"`
+	PlanId      int      `json:"plan_id" validate:"min=0"`
+	Tags        []string `json:"tags" validate:"max=32,dive,required,max=32,excludesall=0x2C"`
+	Overage     float64  `json:"overage" validate:"min=0,max=1000000"`
+	OverageUnit string   `json:"overage_unit" validate:"omitempty,oneof=percent gb"`
+	QuotaLimit  string   `json:"quota_limit" validate:"omitempty,oneof=hard soft"`
+	Protocols   []string `json:"protocols" validate:"dive,oneof=direct relay reverse"`
+	MaxIps      int      `json:"max_ips" validate:"min=0,max=1024"`
+	IpLimit     string   `json:"ip_limit" validate:"omitempty,oneof=disable flag"`
+	// ShadowsocksMethod is the cipher of the user, the default one when empty.
+	ShadowsocksMethod string `json:"shadowsocks_method" validate:"omitempty,oneof=chacha20-ietf-poly1305 aes-128-gcm aes-256-gcm 2022-blake3-aes-128-gcm 2022-blake3-aes-256-gcm"`
+	// ShadowsocksPassword and Identity keep the existing credentials of users, like ones moved from other servers.
+	// They are generated for new users and kept for existing ones when empty.
+	ShadowsocksPassword string `json:"shadowsocks_password" validate:"omitempty,min=8,max=64"`
+	Identity            string `json:"identity" validate:"omitempty,min=8,max=64,printascii,excludesall= #%&+/?"`
+}
+
+type UsersStoreBulkRequest struct {
+	Names   []string `json:"names" validate:"required,min=1,max=1024,unique,dive,required,min=1,max=32"`
+	Enabled bool     `json:"enabled"`
+	PlanId  int      `json:"plan_id" validate:"required,min=1"`
+	Tags    []string `json:"tags" validate:"max=32,dive,required,max=32,excludesall=0x2C"`
+	// ShadowsocksMethod is the cipher of the users, the default one when empty.
+	ShadowsocksMethod string `json:"shadowsocks_method" validate:"omitempty,oneof=chacha20-ietf-poly1305 aes-128-gcm aes-256-gcm 2022-blake3-aes-128-gcm 2022-blake3-aes-256-gcm"`
 }
 
 type UsersUpdateRequest struct {
 	UsersStoreRequest
 }
 
+type UsersRenewRequest struct {
+	Days int `json:"days" validate:"required,min=1,max=3650"`
+}
+
 type UsersUpdatePartialRequest struct {
-	Usage   *float64 `json:"usage"`
-	Enabled *bool    `json:"enabled"`
+	Usage     *float64  `json:"usage"`
+	Enabled   *bool     `json:"enabled"`
+	Protocols *[]string `json:"protocols" validate:"omitempty,dive,oneof=direct relay reverse"`
+}
+
+// UsersBatchResponse lists the users affected by a batch operation.
+type UsersBatchResponse struct {
+	Count int   `json:"count"`
+	Users []int `json:"users"`
+}
+
+// UsersQuery is the selector, order, and page of a users index request.
+type UsersQuery struct {
+	Selector *database.UserSelector
+	Sort     string
+	Offset   int
+	// Limit is the maximum number of the users in the page, zero for all of them.
+	Limit int
+}
+
+// ParseUsersQuery parses the users index request: the selector, sort (a field, prefixed by "-" for the descending
+// order), offset, and limit; it writes the error response and returns nil for invalid ones.
+func ParseUsersQuery(c echo.Context) (*UsersQuery, error) {
+	s, err := parseUserSelector(c)
+	if s == nil {
+		return nil, err
+	}
+
+	q := &UsersQuery{Selector: s, Sort: c.QueryParam("sort")}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if _, found := userComparators[strings.TrimPrefix(q.Sort, "-")]; !found {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{
+			"message": "Invalid query parameter: sort.",
+		})
+	}
+
+	for name, field := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		if v := c.QueryParam(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || (name == "limit" && n > config.MaxUsersCount) {
+				return nil, c.JSON(http.StatusBadRequest, map[string]string{
+					"message": fmt.Sprintf("Invalid query parameter: %s.", name),
+				})
+			}
+			*field = n
+		}
+	}
+
+	return q, nil
+}
+
+// Users returns the page of the users matching the query in its order, and the number of all the matching users.
+// The caller must hold the lock.
+func (q *UsersQuery) Users(d *database.Database) ([]*database.User, int) {
+	compare := userComparators[strings.TrimPrefix(q.Sort, "-")]
+
+	users := d.SelectUsers(q.Selector, time.Now())
+	slices.SortStableFunc(users, func(a, b *database.User) int {
+		if strings.HasPrefix(q.Sort, "-") {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+
+	matched := len(users)
+	users = users[min(q.Offset, len(users)):]
+	if q.Limit > 0 {
+		users = users[:min(q.Limit, len(users))]
+	}
+
+	return users, matched
 }
 
+// UsersIndex lists the users (all of them by default) as they are stored, with their passwords,
+// as other instances import them from here. The paginated index without passwords is in v2.
 func UsersIndex(d *database.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return c.JSON(http.StatusOK, d.Content.Users)
+		q, err := ParseUsersQuery(c)
+		if q == nil {
+			return err
+		}
+
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		users, _ := q.Users(d)
+
+		return c.JSON(http.StatusOK, users)
 	}
 }
 
-func UsersStore(coordinator *coordinator.Coordinator, d *database.Database, l *licensor.Licensor) echo.HandlerFunc {
+func UsersUsage(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		q, err := parseUsageQuery(c)
+		if q == nil {
+			return err
+		}
+
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		var user *database.User
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				user = u
+			}
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		points, err := d.UserUsage(user.Id, q.Granularity, q.From, q.To)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return c.JSON(http.StatusOK, makeUsageResponse(q, points, 1))
+	}
+}
+
+func UsersPeriods(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return c.JSON(http.StatusOK, d.UserPeriods(u.Id))
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func UsersEvents(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return c.JSON(http.StatusOK, d.UserEvents(u.Id))
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func UsersIps(d *database.Database, t *tracker.Tracker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				return c.JSON(http.StatusOK, t.Ips(u.Id))
+			}
+		}
+
+		return c.NoContent(http.StatusNotFound)
+	}
+}
+
+func UsersStore(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	l *licensor.Licensor,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request UsersStoreRequest
 		if err := c.Bind(&request); err != nil {
@@ -54,15 +251,8 @@ func UsersStore(coordinator *coordinator.Coordinator, d *database.Database, l *l
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		if len(d.Content.Users) >= config.MaxUsersCount {
-			return c.JSON(http.StatusForbidden, map[string]string{
-				"message": "You have already reached the maximum number of users.",
-			})
-		}
-		if len(d.Content.Users) >= config.FreeUsersCount && !l.Licensed() {
-			return c.JSON(http.StatusForbidden, map[string]string{
-				"message": "You cannot add more users without license.",
-			})
+		if message := checkUsersCount(d, l, 1); message != "" {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": message})
 		}
 
 		for _, u := range d.Content.Users {
@@ -73,31 +263,123 @@ func UsersStore(coordinator *coordinator.Coordinator, d *database.Database, l *l
 			}
 		}
 
-		user := &database.User{}
-		user.Id = d.GenerateUserId()
-		user.Identity = d.GenerateUserIdentity()
-		user.CreatedAt = time.Now().UnixMilli()
-		user.ShadowsocksMethod = config.ShadowsocksMethod
-		user.ShadowsocksPassword = d.GenerateUserPassword()
-		user.Usage = request.Usage
-		user.UsageBytes = int64(request.Usage * 1000 * 1000 * 1000)
-		user.Name = request.Name
+		method := cmp.Or(request.ShadowsocksMethod, config.ShadowsocksMethod)
+		if message := checkUserSecrets(d, nil, method, request.ShadowsocksPassword, request.Identity); message != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": message})
+		}
+
+		var plan *database.Plan
+		if request.PlanId > 0 {
+			if plan = d.FindPlan(request.PlanId); plan == nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "The plan does not exist.",
+				})
+			}
+		}
+
+		user := newUser(d, request.Name, method, request.Enabled)
+		if request.ShadowsocksPassword != "" {
+			user.ShadowsocksPassword = request.ShadowsocksPassword
+		}
+		if request.Identity != "" {
+			user.Identity = request.Identity
+		}
+		user.SetUsage(request.Usage)
 		user.Quota = request.Quota
-		user.Enabled = request.Enabled
+		user.QuotaPolicy = request.QuotaPolicy
+		user.Overage = request.Overage
+		user.OverageUnit = request.OverageUnit
+		user.QuotaLimit = request.QuotaLimit
+		user.MaxIps = request.MaxIps
+		user.IpLimit = request.IpLimit
+		user.ExpiresAt = request.ExpiresAt
+		user.ResetPolicy = request.ResetPolicy
+		user.ResetDays = request.ResetDays
+		user.Tags = normalizeValues(request.Tags)
+		user.Protocols = normalizeValues(request.Protocols)
+		if plan != nil {
+			plan.Subscribe(user, time.Now())
+		}
 
 		d.Content.Users = append(d.Content.Users, user)
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveUsers(user); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "users.store", userTarget(user.Id), nil, user)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusCreated, user)
 	}
 }
 
-func UsersUpdate(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func UsersStoreBulk(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	l *licensor.Licensor,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request UsersStoreBulkRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		if message := checkUsersCount(d, l, len(request.Names)); message != "" {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": message})
+		}
+
+		for _, u := range d.Content.Users {
+			if slices.Contains(request.Names, u.Name) {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": fmt.Sprintf("The name %s is already taken.", u.Name),
+				})
+			}
+		}
+
+		plan := d.FindPlan(request.PlanId)
+		if plan == nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "The plan does not exist.",
+			})
+		}
+
+		var users []*database.User
+		for _, name := range request.Names {
+			user := newUser(d, name, request.ShadowsocksMethod, request.Enabled)
+			user.Tags = normalizeValues(request.Tags)
+			plan.Subscribe(user, time.Now())
+			d.Content.Users = append(d.Content.Users, user)
+			users = append(users, user)
+		}
+
+		if err := d.SaveUsers(users...); err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, u := range users {
+			record(a, c, "users.store_bulk", userTarget(u.Id), nil, u)
+		}
+
+		go coordinator.SyncConfigs()
+
+		return c.JSON(http.StatusCreated, users)
+	}
+}
+
+func UsersUpdate(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request UsersUpdateRequest
 		if err := c.Bind(&request); err != nil {
@@ -130,21 +412,74 @@ func UsersUpdate(coordinator *coordinator.Coordinator, d *database.Database) ech
 			return c.NoContent(http.StatusNotFound)
 		}
 
+		method := cmp.Or(request.ShadowsocksMethod, user.ShadowsocksMethod)
+		if message := checkUserSecrets(d, user, method, request.ShadowsocksPassword, request.Identity); message != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": message})
+		}
+
+		var plan *database.Plan
+		if request.PlanId > 0 && request.PlanId != user.PlanId {
+			if plan = d.FindPlan(request.PlanId); plan == nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "The plan does not exist.",
+				})
+			}
+		}
+
+		before := *user
+
 		user.Name = request.Name
 		user.Quota = request.Quota
-		user.Enabled = request.Enabled
+		user.QuotaPolicy = request.QuotaPolicy
+		user.Overage = request.Overage
+		user.OverageUnit = request.OverageUnit
+		user.QuotaLimit = request.QuotaLimit
+		user.MaxIps = request.MaxIps
+		user.IpLimit = request.IpLimit
+		user.ExpiresAt = request.ExpiresAt
+		user.ResetPolicy = request.ResetPolicy
+		user.ResetDays = request.ResetDays
+		user.Tags = normalizeValues(request.Tags)
+		user.Protocols = normalizeValues(request.Protocols)
+		user.SetEnabled(request.Enabled)
+
+		// Passwords of other methods may not be valid for the new one, like the keys of 2022 methods.
+		if request.ShadowsocksMethod != "" && request.ShadowsocksMethod != user.ShadowsocksMethod {
+			user.ShadowsocksMethod = request.ShadowsocksMethod
+			user.ShadowsocksPassword = d.GenerateUserPassword(user.ShadowsocksMethod)
+			user.PreviousPassword, user.PreviousPasswordUntil = "", 0
+			user.Credentials = user.CloneCredentials()
+			for _, cr := range user.Credentials {
+				cr.Password = d.GenerateUserPassword(user.ShadowsocksMethod)
+			}
+		}
+		if request.ShadowsocksPassword != "" {
+			user.ShadowsocksPassword = request.ShadowsocksPassword
+		}
+		if request.Identity != "" {
+			user.Identity = request.Identity
+		}
+		user.ReclaimPrevious()
+
+		if plan != nil {
+			plan.Subscribe(user, time.Now())
+		} else if request.PlanId == 0 {
+			user.PlanId = 0
+		}
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveUsers(user); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "users.update", userTarget(user.Id), &before, user)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusOK, user)
 	}
 }
 
-func UsersUpdatePartial(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func UsersUpdatePartial(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request UsersUpdatePartialRequest
 		if err := c.Bind(&request); err != nil {
@@ -171,25 +506,35 @@ func UsersUpdatePartial(coordinator *coordinator.Coordinator, d *database.Databa
 			return c.NoContent(http.StatusNotFound)
 		}
 
+		before := *user
+
 		if request.Usage != nil {
-			user.Usage = *request.Usage
-			user.UsageBytes = int64(*request.Usage * 1000 * 1000 * 1000)
+			user.SetUsage(*request.Usage)
 		}
 		if request.Enabled != nil {
-			user.Enabled = true
+			user.SetEnabled(true)
+		}
+		if request.Protocols != nil {
+			user.Protocols = normalizeValues(*request.Protocols)
 		}
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveUsers(user); err != nil {
 			return errors.WithStack(err)
 		}
 
+		record(a, c, "users.update_partial", userTarget(user.Id), &before, user)
+
 		go coordinator.SyncConfigs()
 
 		return c.JSON(http.StatusOK, user)
 	}
 }
 
-func UsersUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func UsersUpdatePartialBatch(
+	coordinator *coordinator.Coordinator,
+	d *database.Database,
+	a *auditor.Auditor,
+) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var request UsersUpdatePartialRequest
 		if err := c.Bind(&request); err != nil {
@@ -203,30 +548,98 @@ func UsersUpdatePartialBatch(coordinator *coordinator.Coordinator, d *database.D
 			})
 		}
 
+		s, err := parseUserSelector(c)
+		if s == nil {
+			return err
+		}
+
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		for _, user := range d.Content.Users {
+		users := d.SelectUsers(s, time.Now())
+
+		befores := map[int]database.User{}
+		for _, user := range users {
+			befores[user.Id] = *user
 			if request.Usage != nil {
-				user.Usage = *request.Usage
-				user.UsageBytes = int64(*request.Usage * 1000 * 1000 * 1000)
+				user.SetUsage(*request.Usage)
 			}
 			if request.Enabled != nil {
-				user.Enabled = *request.Enabled
+				user.SetEnabled(*request.Enabled)
+			}
+			if request.Protocols != nil {
+				user.Protocols = normalizeValues(*request.Protocols)
 			}
 		}
 
-		if err := d.Save(); err != nil {
+		if err := d.SaveUsers(users...); err != nil {
 			return errors.WithStack(err)
 		}
 
+		for _, user := range users {
+			before := befores[user.Id]
+			record(a, c, "users.update_batch", userTarget(user.Id), &before, user)
+		}
+
 		go coordinator.SyncConfigs()
 
-		return c.NoContent(http.StatusNoContent)
+		return c.JSON(http.StatusOK, makeUsersBatchResponse(users))
 	}
 }
 
-func UsersDelete(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func UsersRenew(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var request UsersRenewRequest
+		if err := c.Bind(&request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Cannot parse the request body.",
+			})
+		}
+		if err := validator.New().Struct(request); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"message": fmt.Sprintf("Validation error: %v", err.Error()),
+			})
+		}
+
+		d.Locker.Lock()
+		defer d.Locker.Unlock()
+
+		var user *database.User
+		for _, u := range d.Content.Users {
+			if strconv.Itoa(u.Id) == c.Param("id") {
+				user = u
+			}
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		before := *user
+
+		// Active subscriptions are extended from their expiration date, and the others from now.
+		from := time.Now()
+		if user.ExpiresAt > from.UnixMilli() {
+			from = time.UnixMilli(user.ExpiresAt)
+		}
+		user.ExpiresAt = from.AddDate(0, 0, request.Days).UnixMilli()
+
+		if !user.Enabled && user.DisabledReason == database.DisabledReasonExpired {
+			user.Enable()
+		}
+
+		if err := d.SaveUsers(user); err != nil {
+			return errors.WithStack(err)
+		}
+
+		record(a, c, "users.renew", userTarget(user.Id), &before, user)
+
+		go coordinator.SyncConfigs()
+
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+func UsersDelete(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
@@ -234,9 +647,10 @@ func UsersDelete(coordinator *coordinator.Coordinator, d *database.Database) ech
 		for i, u := range d.Content.Users {
 			if strconv.Itoa(u.Id) == c.Param("id") {
 				d.Content.Users = slices.Delete(d.Content.Users, i, i+1)
-				if err := d.Save(); err != nil {
+				if err := d.DeleteUsers(u.Id); err != nil {
 					return errors.WithStack(err)
 				}
+				record(a, c, "users.delete", userTarget(u.Id), u, nil)
 				go coordinator.SyncConfigs()
 				break
 			}
@@ -246,47 +660,164 @@ func UsersDelete(coordinator *coordinator.Coordinator, d *database.Database) ech
 	}
 }
 
-func UsersDeleteBatch(coordinator *coordinator.Coordinator, d *database.Database) echo.HandlerFunc {
+func UsersDeleteBatch(coordinator *coordinator.Coordinator, d *database.Database, a *auditor.Auditor) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		enabledParam := c.QueryParam("enabled")
-		if enabledParam != "" && enabledParam != "true" && enabledParam != "false" {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"message": "Invalid query parameter.",
-			})
-		}
-
-		var enabled *bool
-		if enabledParam != "" {
-			enabledBool := enabledParam == "true"
-			enabled = &enabledBool
+		s, err := parseUserSelector(c)
+		if s == nil {
+			return err
 		}
 
 		d.Locker.Lock()
 		defer d.Locker.Unlock()
 
-		var newUsers []*database.User
-
-		if enabled != nil {
-			for _, u := range d.Content.Users {
-				if u.Enabled != *enabled {
-					newUsers = append(newUsers, u)
-				}
-			}
-			d.Content.Users = newUsers
+		deletedUsers := d.SelectUsers(s, time.Now())
+		var deleted []int
+		for _, u := range deletedUsers {
+			deleted = append(deleted, u.Id)
 		}
 
-		if newUsers == nil {
-			newUsers = []*database.User{}
+		newUsers := []*database.User{}
+		for _, u := range d.Content.Users {
+			if !slices.Contains(deleted, u.Id) {
+				newUsers = append(newUsers, u)
+			}
 		}
 
 		d.Content.Users = newUsers
 
-		if err := d.Save(); err != nil {
+		if err := d.DeleteUsers(deleted...); err != nil {
 			return errors.WithStack(err)
 		}
 
+		for _, u := range deletedUsers {
+			record(a, c, "users.delete_batch", userTarget(u.Id), u, nil)
+		}
+
 		go coordinator.SyncConfigs()
 
-		return c.NoContent(http.StatusNoContent)
+		return c.JSON(http.StatusOK, makeUsersBatchResponse(deletedUsers))
+	}
+}
+
+// newUser makes a new user with generated credentials for the method (the default one when empty);
+// the caller appends it to the users.
+func newUser(d *database.Database, name, method string, enabled bool) *database.User {
+	if method == "" {
+		method = config.ShadowsocksMethod
+	}
+
+	user := &database.User{}
+	user.Id = d.GenerateUserId()
+	user.Identity = d.GenerateUserIdentity()
+	user.CreatedAt = time.Now().UnixMilli()
+	user.ShadowsocksMethod = method
+	user.ShadowsocksPassword = d.GenerateUserPassword(method)
+	user.UsageResetAt = time.Now().UnixMilli()
+	user.Name = name
+	user.Enabled = enabled
+	return user
+}
+
+// checkUserSecrets returns the reason the password (of the method) or the identity cannot be given to the user, if any.
+// Empty values are not checked, as they are generated or kept; the user is nil for new users.
+func checkUserSecrets(d *database.Database, user *database.User, method, password, identity string) string {
+	if password != "" && !database.ValidPassword(method, password) {
+		return "The password is not a valid key for the method."
+	}
+	if database.IsSealed(password) || database.IsSealed(identity) {
+		return "The password and the identity cannot start with the prefix of encrypted values."
+	}
+	if password != "" && d.IsPasswordTaken(password, user) {
+		return "The password is already taken."
 	}
+	if identity != "" && d.IsIdentityTaken(identity, user) {
+		return "The identity is already taken."
+	}
+	return ""
+}
+
+// checkUsersCount returns the reason new users cannot be added, if any.
+func checkUsersCount(d *database.Database, l *licensor.Licensor, count int) string {
+	if len(d.Content.Users)+count > config.MaxUsersCount {
+		return "You have already reached the maximum number of users."
+	}
+	if len(d.Content.Users)+count > config.FreeUsersCount && !l.Licensed() {
+		return "You cannot add more users without license."
+	}
+	return ""
+}
+
+// userComparators are the fields of the users that the index can be sorted by.
+var userComparators = map[string]func(a, b *database.User) int{
+	"id":           func(a, b *database.User) int { return cmp.Compare(a.Id, b.Id) },
+	"name":         func(a, b *database.User) int { return strings.Compare(a.Name, b.Name) },
+	"quota":        func(a, b *database.User) int { return cmp.Compare(a.Quota, b.Quota) },
+	"usage":        func(a, b *database.User) int { return cmp.Compare(a.UsageBytes, b.UsageBytes) },
+	"expires_at":   func(a, b *database.User) int { return cmp.Compare(a.ExpiresAt, b.ExpiresAt) },
+	"created_at":   func(a, b *database.User) int { return cmp.Compare(a.CreatedAt, b.CreatedAt) },
+	"last_seen_at": func(a, b *database.User) int { return cmp.Compare(a.LastSeenAt, b.LastSeenAt) },
+}
+
+// parseUserSelector parses the user selector from the query parameters: search (a part of the name),
+// ids and tags (comma-separated), enabled, expired, over_quota, and online (true or false), and inactive_days.
+// It returns nil with the written response for invalid parameters.
+func parseUserSelector(c echo.Context) (*database.UserSelector, error) {
+	s := &database.UserSelector{Search: strings.TrimSpace(c.QueryParam("search"))}
+
+	if v := c.QueryParam("ids"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				return nil, c.JSON(http.StatusBadRequest, map[string]string{
+					"message": "Invalid query parameter: ids.",
+				})
+			}
+			s.Ids = append(s.Ids, n)
+		}
+	}
+	if v := c.QueryParam("tags"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				s.Tags = append(s.Tags, tag)
+			}
+		}
+	}
+
+	if v := c.QueryParam("inactive_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, c.JSON(http.StatusBadRequest, map[string]string{
+				"message": "Invalid query parameter: inactive_days.",
+			})
+		}
+		s.InactiveDays = n
+	}
+
+	bools := map[string]**bool{"enabled": &s.Enabled, "expired": &s.Expired, "over_quota": &s.OverQuota, "online": &s.Online}
+	for name, field := range bools {
+		if v := c.QueryParam(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, c.JSON(http.StatusBadRequest, map[string]string{
+					"message": fmt.Sprintf("Invalid query parameter: %s.", name),
+				})
+			}
+			*field = &b
+		}
+	}
+
+	return s, nil
+}
+
+func makeUsersBatchResponse(users []*database.User) *UsersBatchResponse {
+	r := &UsersBatchResponse{Count: len(users), Users: []int{}}
+	for _, u := range users {
+		r.Users = append(r.Users, u.Id)
+	}
+	return r
+}
+
+// normalizeValues sorts the values (like tags) and removes the duplicates.
+func normalizeValues(values []string) []string {
+	return slices.Compact(slices.Sorted(slices.Values(values)))
 }
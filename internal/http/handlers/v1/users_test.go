@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsersIndex(t *testing.T) {
+	d := database.New(nil, nil)
+	for i := 1; i <= 150; i++ {
+		d.Content.Users = append(d.Content.Users, &database.User{
+			Id:                  i,
+			Name:                fmt.Sprintf("user-%d", i),
+			ShadowsocksPassword: fmt.Sprintf("password-%d", i),
+		})
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		count int
+		first int
+	}{
+		{"all users", "", 150, 1},
+		{"page", "offset=140&limit=5", 5, 141},
+		{"sorted and selected", "sort=-id&ids=3,4,5", 3, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			if err := UsersIndex(d)(echo.New().NewContext(request, recorder)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The index keeps its array shape with the passwords, for the clients like imports.
+			var users []*database.User
+			if err := json.Unmarshal(recorder.Body.Bytes(), &users); err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != tt.count || users[0].Id != tt.first {
+				t.Errorf("got %d users from #%d, want %d from #%d", len(users), users[0].Id, tt.count, tt.first)
+			}
+			if users[0].ShadowsocksPassword == "" {
+				t.Errorf("the passwords are left out")
+			}
+		})
+	}
+}
+
+func TestUsersUpdateMissingPlan(t *testing.T) {
+	d := database.New(nil, nil)
+	u := &database.User{
+		Id:                  1,
+		Name:                "user",
+		Identity:            "identity",
+		Quota:               10,
+		Enabled:             true,
+		ShadowsocksMethod:   "chacha20-ietf-poly1305",
+		ShadowsocksPassword: "password",
+	}
+	d.Content.Users = []*database.User{u}
+	before := *u
+
+	body := `{"name":"renamed","quota":20,"enabled":false,"plan_id":7,"shadowsocks_method":"aes-128-gcm"}`
+	request := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder := httptest.NewRecorder()
+	context := echo.New().NewContext(request, recorder)
+	context.SetParamNames("id")
+	context.SetParamValues("1")
+
+	if err := UsersUpdate(nil, d, nil)(context); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	if u.Name != before.Name || u.Quota != before.Quota || u.Enabled != before.Enabled ||
+		u.ShadowsocksMethod != before.ShadowsocksMethod || u.ShadowsocksPassword != before.ShadowsocksPassword {
+		t.Errorf("the rejected update changed the user: %+v", u)
+	}
+}
+
+func TestCheckUserSecrets(t *testing.T) {
+	d := database.New(nil, nil)
+	u := &database.User{Id: 1, Identity: "identity", ShadowsocksPassword: "password"}
+	other := &database.User{Id: 2, Identity: "other-identity", ShadowsocksPassword: "other-password"}
+	d.Content.Users = []*database.User{u, other}
+
+	tests := []struct {
+		name     string
+		method   string
+		password string
+		identity string
+		valid    bool
+	}{
+		{"kept secrets", "chacha20-ietf-poly1305", "", "", true},
+		{"own secrets", "chacha20-ietf-poly1305", "password", "identity", true},
+		{"new secrets", "chacha20-ietf-poly1305", "new-password", "new-identity", true},
+		{"taken password", "chacha20-ietf-poly1305", "other-password", "", false},
+		{"taken identity", "chacha20-ietf-poly1305", "", "other-identity", false},
+		{"invalid key", "2022-blake3-aes-128-gcm", "new-password", "", false},
+		{"sealed password", "chacha20-ietf-poly1305", "enc:v1:password", "", false},
+		{"sealed identity", "chacha20-ietf-poly1305", "", "enc:v1:identity", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := checkUserSecrets(d, u, tt.method, tt.password, tt.identity)
+			if (message == "") != tt.valid {
+				t.Errorf("checkUserSecrets() = %q, want valid %v", message, tt.valid)
+			}
+		})
+	}
+}
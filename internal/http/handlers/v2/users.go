@@ -0,0 +1,68 @@
+package v2
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/miladrahimi/p-manager/internal/http/handlers/v1"
+	"net/http"
+)
+
+// DefaultLimit is the number of the users in a page of the index when no limit is given.
+const DefaultLimit = 100
+
+type UsersIndexResponse struct {
+	Total   int              `json:"total"`
+	Matched int              `json:"matched"`
+	Enabled int              `json:"enabled"`
+	Online  int              `json:"online"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+	Users   []*database.User `json:"users"`
+}
+
+// UsersIndex lists a page of the users with the counts of all of them, and leaves the passwords out.
+// It takes the query of the v1 index, with DefaultLimit users in a page by default.
+func UsersIndex(d *database.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		q, err := v1.ParseUsersQuery(c)
+		if q == nil {
+			return err
+		}
+		if c.QueryParam("limit") == "" {
+			q.Limit = DefaultLimit
+		}
+
+		d.Locker.RLock()
+		defer d.Locker.RUnlock()
+
+		users, matched := q.Users(d)
+
+		r := UsersIndexResponse{
+			Total:   len(d.Content.Users),
+			Matched: matched,
+			Offset:  q.Offset,
+			Limit:   q.Limit,
+			Users:   []*database.User{},
+		}
+		for _, u := range d.Content.Users {
+			if u.Enabled {
+				r.Enabled++
+			}
+			if u.Online {
+				r.Online++
+			}
+		}
+
+		for _, u := range users {
+			user := *u
+			user.ShadowsocksPassword, user.PreviousPassword = "", ""
+			user.Credentials = user.CloneCredentials()
+			for _, cr := range user.Credentials {
+				cr.Password = ""
+			}
+			r.Users = append(r.Users, &user)
+		}
+
+		return c.JSON(http.StatusOK, r)
+	}
+}
@@ -0,0 +1,75 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newUsersDatabase(count int) *database.Database {
+	d := database.New(nil, nil)
+	for i := 1; i <= count; i++ {
+		d.Content.Users = append(d.Content.Users, &database.User{
+			Id:                  i,
+			Name:                fmt.Sprintf("user-%d", i),
+			ShadowsocksPassword: fmt.Sprintf("password-%d", i),
+		})
+	}
+	return d
+}
+
+func serve(h echo.HandlerFunc, query string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	_ = h(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/?"+query, nil), recorder))
+	return recorder
+}
+
+func TestUsersIndex(t *testing.T) {
+	d := newUsersDatabase(150)
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		count   int
+		first   int
+		matched int
+		limit   int
+	}{
+		{"default page", "", http.StatusOK, DefaultLimit, 1, 150, DefaultLimit},
+		{"all users", "limit=0", http.StatusOK, 150, 1, 150, 0},
+		{"second page", "offset=100&limit=100", http.StatusOK, 50, 101, 150, 100},
+		{"sorted and selected", "sort=-id&ids=3,4,5", http.StatusOK, 3, 5, 3, DefaultLimit},
+		{"invalid limit", "limit=-1", http.StatusBadRequest, 0, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(UsersIndex(d), tt.query)
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var r UsersIndexResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.Users) != tt.count || r.Users[0].Id != tt.first {
+				t.Errorf("got %d users from #%d, want %d from #%d", len(r.Users), r.Users[0].Id, tt.count, tt.first)
+			}
+			if r.Total != 150 || r.Matched != tt.matched || r.Limit != tt.limit {
+				t.Errorf("total = %d, matched = %d, limit = %d, want 150, %d, %d", r.Total, r.Matched, r.Limit, tt.matched, tt.limit)
+			}
+			if r.Users[0].ShadowsocksPassword != "" {
+				t.Errorf("the passwords are not left out")
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/miladrahimi/p-manager/internal/auditor"
 	"github.com/miladrahimi/p-manager/internal/config"
 	"github.com/miladrahimi/p-manager/internal/coordinator"
 	"github.com/miladrahimi/p-manager/internal/database"
@@ -13,7 +14,9 @@ import (
 	"github.com/miladrahimi/p-manager/internal/http/client"
 	"github.com/miladrahimi/p-manager/internal/http/handlers/pages"
 	"github.com/miladrahimi/p-manager/internal/http/handlers/v1"
+	"github.com/miladrahimi/p-manager/internal/http/handlers/v2"
 	"github.com/miladrahimi/p-manager/internal/licensor"
+	"github.com/miladrahimi/p-manager/internal/tracker"
 	"github.com/miladrahimi/p-node/pkg/http/middleware"
 	"github.com/miladrahimi/p-node/pkg/http/validator"
 	"github.com/miladrahimi/p-node/pkg/logger"
@@ -31,6 +34,8 @@ type Server struct {
 	enigma      *enigma.Enigma
 	licensor    *licensor.Licensor
 	hc          *client.Client
+	auditor     *auditor.Auditor
+	tracker     *tracker.Tracker
 }
 
 // Run defines the required HTTP routes and starts the HTTP Server.
@@ -46,7 +51,10 @@ func (s *Server) Run() {
 	g1.POST("/sign-in", v1.SignIn(s.database, s.enigma))
 
 	g1.GET("/profile", v1.ProfileShow(s.database))
-	g1.POST("/profile/links/regenerate", v1.ProfileRegenerate(s.coordinator, s.database))
+	g1.GET("/profile/usage", v1.ProfileUsage(s.database))
+	g1.POST("/profile/links/regenerate", v1.ProfileRegenerate(s.coordinator, s.database, s.auditor))
+	g1.POST("/profile/credentials/:credential/regenerate", v1.ProfileCredentialsRegenerate(s.coordinator, s.database, s.auditor))
+	g1.DELETE("/profile/credentials/:credential", v1.ProfileCredentialsDelete(s.coordinator, s.database, s.auditor))
 
 	g2 := s.e.Group("/v1")
 	g2.Use(middleware.Authorize(func() string {
@@ -54,29 +62,64 @@ func (s *Server) Run() {
 	}))
 
 	g2.GET("/users", v1.UsersIndex(s.database))
-	g2.POST("/users", v1.UsersStore(s.coordinator, s.database, s.licensor))
-	g2.PATCH("/users", v1.UsersUpdatePartialBatch(s.coordinator, s.database))
-	g2.PUT("/users/:id", v1.UsersUpdate(s.coordinator, s.database))
-	g2.PATCH("/users/:id", v1.UsersUpdatePartial(s.coordinator, s.database))
-	g2.DELETE("/users/:id", v1.UsersDelete(s.coordinator, s.database))
-	g2.DELETE("/users", v1.UsersDeleteBatch(s.coordinator, s.database))
+	g2.POST("/users", v1.UsersStore(s.coordinator, s.database, s.licensor, s.auditor))
+	g2.POST("/users/bulk", v1.UsersStoreBulk(s.coordinator, s.database, s.licensor, s.auditor))
+	g2.PATCH("/users", v1.UsersUpdatePartialBatch(s.coordinator, s.database, s.auditor))
+	g2.PUT("/users/:id", v1.UsersUpdate(s.coordinator, s.database, s.auditor))
+	g2.PATCH("/users/:id", v1.UsersUpdatePartial(s.coordinator, s.database, s.auditor))
+	g2.DELETE("/users/:id", v1.UsersDelete(s.coordinator, s.database, s.auditor))
+	g2.DELETE("/users", v1.UsersDeleteBatch(s.coordinator, s.database, s.auditor))
+	g2.GET("/users/:id/usage", v1.UsersUsage(s.database))
+	g2.GET("/users/:id/periods", v1.UsersPeriods(s.database))
+	g2.GET("/users/:id/events", v1.UsersEvents(s.database))
+	g2.GET("/users/:id/ips", v1.UsersIps(s.database, s.tracker))
+	g2.POST("/users/:id/renew", v1.UsersRenew(s.coordinator, s.database, s.auditor))
+	g2.POST("/users/:id/rotate", v1.UsersRotate(s.coordinator, s.database, s.auditor))
+	g2.GET("/users/:id/ledger", v1.UsersLedger(s.database))
+	g2.POST("/users/:id/topups", v1.UsersTopupsStore(s.coordinator, s.database, s.auditor))
+	g2.POST("/users/:id/adjustments", v1.UsersAdjustmentsStore(s.coordinator, s.database, s.auditor))
+	g2.POST("/users/:id/credentials", v1.UsersCredentialsStore(s.coordinator, s.database, s.auditor))
+	g2.POST("/users/:id/credentials/:credential/regenerate", v1.UsersCredentialsRegenerate(s.coordinator, s.database, s.auditor))
+	g2.DELETE("/users/:id/credentials/:credential", v1.UsersCredentialsDelete(s.coordinator, s.database, s.auditor))
+
+	g2.GET("/plans", v1.PlansIndex(s.database))
+	g2.POST("/plans", v1.PlansStore(s.database, s.auditor))
+	g2.PUT("/plans/:id", v1.PlansUpdate(s.coordinator, s.database, s.auditor))
+	g2.DELETE("/plans/:id", v1.PlansDelete(s.database, s.auditor))
 
 	g2.GET("/nodes", v1.NodesIndex(s.database))
-	g2.POST("/nodes", v1.NodesStore(s.coordinator, s.database))
-	g2.PATCH("/nodes", v1.NodesUpdatePartialBatch(s.coordinator, s.database))
-	g2.PUT("/nodes/:id", v1.NodesUpdate(s.coordinator, s.database))
-	g2.DELETE("/nodes/:id", v1.NodesDelete(s.coordinator, s.database))
+	g2.GET("/nodes/usage", v1.NodesUsageIndex(s.database))
+	g2.GET("/nodes/:id/usage", v1.NodesUsage(s.database))
+	g2.POST("/nodes", v1.NodesStore(s.coordinator, s.database, s.auditor))
+	g2.PATCH("/nodes", v1.NodesUpdatePartialBatch(s.coordinator, s.database, s.auditor))
+	g2.PUT("/nodes/:id", v1.NodesUpdate(s.coordinator, s.database, s.auditor))
+	g2.DELETE("/nodes/:id", v1.NodesDelete(s.coordinator, s.database, s.auditor))
 
 	g2.GET("/stats", v1.StatsIndex(s.database))
-	g2.PATCH("/stats", v1.StatsUpdatePartial(s.database))
+	g2.GET("/stats/usage", v1.StatsUsage(s.database))
+	g2.PATCH("/stats", v1.StatsUpdatePartial(s.database, s.auditor))
 
 	g2.GET("/information", v1.InformationIndex(s.licensor))
 
 	g2.GET("/settings", v1.SettingsShow(s.database))
-	g2.POST("/settings", v1.SettingsUpdate(s.coordinator, s.database))
-	g2.POST("/settings/xray/restart", v1.SettingsXrayRestart(s.coordinator))
+	g2.POST("/settings", v1.SettingsUpdate(s.coordinator, s.database, s.auditor))
+	g2.POST("/settings/xray/restart", v1.SettingsXrayRestart(s.coordinator, s.auditor))
 
-	g2.POST("/imports", v1.ImportsStore(s.database, s.hc))
+	g2.POST("/imports", v1.ImportsStore(s.database, s.hc, s.auditor))
+
+	g2.GET("/backups", v1.BackupsIndex(s.database))
+	g2.POST("/backups", v1.BackupsStore(s.database, s.auditor))
+	g2.GET("/backups/:name", v1.BackupsShow(s.database))
+	g2.POST("/backups/:name/restore", v1.BackupsRestore(s.coordinator, s.database, s.licensor, s.auditor))
+
+	g2.GET("/audit", v1.AuditIndex(s.auditor))
+
+	g3 := s.e.Group("/v2")
+	g3.Use(middleware.Authorize(func() string {
+		return s.database.Content.Settings.AdminPassword
+	}))
+
+	g3.GET("/users", v2.UsersIndex(s.database))
 
 	go func() {
 		address := fmt.Sprintf("%s:%d", s.config.HttpServer.Host, s.config.HttpServer.Port)
@@ -111,6 +154,8 @@ func New(
 	enigma *enigma.Enigma,
 	licensor *licensor.Licensor,
 	hc *client.Client,
+	auditor *auditor.Auditor,
+	tracker *tracker.Tracker,
 ) *Server {
 	e := echo.New()
 	e.HideBanner = true
@@ -124,5 +169,7 @@ func New(
 		enigma:      enigma,
 		licensor:    licensor,
 		hc:          hc,
+		auditor:     auditor,
+		tracker:     tracker,
 	}
 }
@@ -0,0 +1,70 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/database"
+	"github.com/miladrahimi/p-manager/internal/http/client"
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// Notification is a user event sent to the notification channels.
+type Notification struct {
+	UserId   int    `json:"user_id"`
+	UserName string `json:"user_name"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+	Time     int64  `json:"time"`
+}
+
+// Notifier sends notifications to the channels configured in the settings (a webhook and a Telegram chat).
+type Notifier struct {
+	l        *logger.Logger
+	hc       *client.Client
+	database *database.Database
+}
+
+// Notify sends the notifications to every configured channel.
+// Failures are logged only, since notifications must not break the notified actions.
+func (n *Notifier) Notify(notifications ...*Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	n.database.Locker.RLock()
+	settings := *n.database.Content.Settings
+	n.database.Locker.RUnlock()
+
+	for _, notification := range notifications {
+		if settings.WebhookUrl != "" {
+			if _, err := n.hc.Do(http.MethodPost, settings.WebhookUrl, "", notification); err != nil {
+				n.l.Warn("notifier: cannot call webhook", zap.Error(errors.WithStack(err)))
+			}
+		}
+		if settings.TelegramToken != "" {
+			if err := n.telegram(settings.TelegramToken, settings.TelegramChat, notification); err != nil {
+				n.l.Warn("notifier: cannot send telegram message", zap.Error(errors.WithStack(err)))
+			}
+		}
+	}
+}
+
+func (n *Notifier) telegram(token, chat string, notification *Notification) error {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", token)
+	_, err := n.hc.Do(http.MethodPost, url, "", map[string]string{
+		"chat_id": chat,
+		"text":    fmt.Sprintf("%s (#%d): %s", notification.UserName, notification.UserId, notification.Message),
+	})
+	if err != nil {
+		// The token is a part of the URL, which errors of the client include.
+		return errors.New(strings.ReplaceAll(err.Error(), token, "[redacted]"))
+	}
+	return nil
+}
+
+func New(l *logger.Logger, hc *client.Client, database *database.Database) *Notifier {
+	return &Notifier{l: l, hc: hc, database: database}
+}
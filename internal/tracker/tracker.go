@@ -0,0 +1,177 @@
+package tracker
+
+import (
+	"bufio"
+	"cmp"
+	"github.com/cockroachdb/errors"
+	"github.com/miladrahimi/p-manager/internal/config"
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogLayout is the layout of the times in the Xray access log, which have microseconds in newer versions.
+const accessLogLayout = "2006/01/02 15:04:05.999999"
+
+// Ip is an IP a user has connected from.
+type Ip struct {
+	Ip         string `json:"ip"`
+	LastSeenAt int64  `json:"last_seen_at"`
+}
+
+// Tracker follows the Xray access log and keeps the IPs each user has recently connected from.
+// The IPs are kept in memory only, so they are lost on restarts.
+type Tracker struct {
+	c      *config.Config
+	l      *logger.Logger
+	locker *sync.Mutex
+	offset int64
+	ips    map[int]map[string]int64
+}
+
+// Follow reads the lines appended to the access log since the last call.
+// The log is truncated once it grows beyond the maximum size, so a few lines written meanwhile may be missed.
+func (t *Tracker) Follow() error {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	file, err := os.Open(t.c.Env.XrayAccessLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if info.Size() < t.offset {
+		t.offset = 0
+	}
+	if _, err = file.Seek(t.offset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// The last line may be incomplete, so it is read again on the next call.
+			break
+		}
+		t.offset += int64(len(line))
+		t.parse(line)
+	}
+
+	t.prune(time.Now().Add(-time.Duration(t.c.Ips.Retention) * time.Hour))
+
+	if t.offset > int64(t.c.Ips.MaxLogSize)*1000*1000 {
+		if err = os.Truncate(t.c.Env.XrayAccessLogPath, 0); err != nil {
+			return errors.WithStack(err)
+		}
+		t.offset = 0
+		t.l.Debug("tracker: access log truncated")
+	}
+
+	return nil
+}
+
+// parse records the IP of an accepted connection in the line, which looks like
+// "2006/01/02 15:04:05 from 1.2.3.4:5678 accepted tcp:example.com:443 [relay >> out] email: 1.2".
+func (t *Tracker) parse(line string) {
+	fields := strings.Fields(line)
+
+	i := slices.Index(fields, "accepted")
+	j := slices.Index(fields, "email:")
+	if i < 1 || j < 0 || j+1 >= len(fields) {
+		return
+	}
+
+	// The clients of the credentials are named "<user>.<credential>".
+	user, _, _ := strings.Cut(fields[j+1], ".")
+	id, err := strconv.Atoi(user)
+	if err != nil {
+		return
+	}
+
+	source := strings.TrimPrefix(strings.TrimPrefix(fields[i-1], "tcp:"), "udp:")
+	ip, _, err := net.SplitHostPort(source)
+	if err != nil {
+		ip = source
+	}
+	if net.ParseIP(ip) == nil {
+		t.l.Debug("tracker: invalid source ignored", zap.String("source", source))
+		return
+	}
+
+	seen := time.Now()
+	if len(fields) > 1 {
+		if s, err := time.ParseInLocation(accessLogLayout, fields[0]+" "+fields[1], time.Local); err == nil {
+			seen = s
+		}
+	}
+
+	if _, found := t.ips[id]; !found {
+		t.ips[id] = map[string]int64{}
+	}
+	t.ips[id][ip] = max(t.ips[id][ip], seen.UnixMilli())
+}
+
+// prune drops the IPs seen before the given time.
+func (t *Tracker) prune(before time.Time) {
+	for id, ips := range t.ips {
+		for ip, seen := range ips {
+			if seen < before.UnixMilli() {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(t.ips, id)
+		}
+	}
+}
+
+// Count returns the number of the distinct IPs the user has connected from since the given time.
+func (t *Tracker) Count(id int, since time.Time) int {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	count := 0
+	for _, seen := range t.ips[id] {
+		if seen >= since.UnixMilli() {
+			count++
+		}
+	}
+	return count
+}
+
+// Ips returns the IPs the user has recently connected from, the latest first.
+func (t *Tracker) Ips(id int) []*Ip {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	ips := make([]*Ip, 0, len(t.ips[id]))
+	for ip, seen := range t.ips[id] {
+		ips = append(ips, &Ip{Ip: ip, LastSeenAt: seen})
+	}
+	slices.SortFunc(ips, func(a, b *Ip) int {
+		return cmp.Or(cmp.Compare(b.LastSeenAt, a.LastSeenAt), strings.Compare(a.Ip, b.Ip))
+	})
+	return ips
+}
+
+func New(c *config.Config, l *logger.Logger) *Tracker {
+	return &Tracker{c: c, l: l, locker: &sync.Mutex{}, ips: map[int]map[string]int64{}}
+}
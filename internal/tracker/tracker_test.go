@@ -0,0 +1,64 @@
+package tracker
+
+import (
+	"github.com/miladrahimi/p-node/pkg/logger"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	l := logger.New("warn", "2006-01-02 15:04:05.000", make(chan struct{}))
+	if err := l.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		name string
+		line string
+		id   int
+		ip   string
+		seen time.Time
+	}{
+		{
+			"ipv4", "2024/01/02 15:04:05 from 1.2.3.4:5678 accepted tcp:example.com:443 [direct >> out] email: 1",
+			1, "1.2.3.4", at,
+		},
+		{
+			"microseconds", "2024/01/02 15:04:05.123456 from tcp:1.2.3.4:5678 accepted tcp:example.com:443 email: 1",
+			1, "1.2.3.4", at.Add(123456 * time.Microsecond),
+		},
+		{
+			"ipv6", "2024/01/02 15:04:05 from [2001:db8::1]:5678 accepted udp:example.com:53 email: 2",
+			2, "2001:db8::1", at,
+		},
+		{
+			"without from", "2024/01/02 15:04:05.5 udp:1.2.3.4:5678 accepted udp:example.com:53 email: 3.2",
+			3, "1.2.3.4", at.Add(500 * time.Millisecond),
+		},
+		{"without email", "2024/01/02 15:04:05 from 1.2.3.4:5678 accepted tcp:example.com:443", 0, "", at},
+		{"invalid source", "2024/01/02 15:04:05 from example.com:5678 accepted tcp:example.com:443 email: 1", 0, "", at},
+		{"rejected", "2024/01/02 15:04:05 from 1.2.3.4:5678 rejected tcp:example.com:443 email: 1", 0, "", at},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := New(nil, l)
+			tracker.parse(tt.line)
+
+			if tt.id == 0 {
+				if len(tracker.ips) != 0 {
+					t.Errorf("ips = %v, want none", tracker.ips)
+				}
+				return
+			}
+			seen, found := tracker.ips[tt.id][tt.ip]
+			if !found {
+				t.Fatalf("ips = %v, want %s for #%d", tracker.ips, tt.ip, tt.id)
+			}
+			if seen != tt.seen.UnixMilli() {
+				t.Errorf("seen = %d, want %d", seen, tt.seen.UnixMilli())
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -19,6 +20,51 @@ func FileExist(path string) bool {
 	return true
 }
 
+// WriteFile atomically replaces the file at the given path.
+// It writes a temporary file in the same directory, syncs it, and renames it over the old one,
+// so a crash or a full disk never leaves a truncated file behind.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	directory := filepath.Dir(path)
+
+	t, err := os.CreateTemp(directory, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(t.Name())
+	}()
+
+	if _, err = t.Write(data); err != nil {
+		_ = t.Close()
+		return err
+	}
+	if err = t.Sync(); err != nil {
+		_ = t.Close()
+		return err
+	}
+	if err = t.Chmod(perm); err != nil {
+		_ = t.Close()
+		return err
+	}
+	if err = t.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(t.Name(), path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	return d.Sync()
+}
+
 // Key32 generates 32-bit keys.
 func Key32() (string, error) {
 	key := make([]byte, 32)
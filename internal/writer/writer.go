@@ -8,7 +8,6 @@ import (
 	"github.com/miladrahimi/p-manager/internal/http/client"
 	"github.com/miladrahimi/p-manager/internal/utils"
 	"github.com/miladrahimi/p-node/pkg/xray"
-	"strconv"
 )
 
 type Writer struct {
@@ -18,23 +17,60 @@ type Writer struct {
 	xray     *xray.Xray
 }
 
-func (w *Writer) clients() []*xray.Client {
+// clients returns the clients of the enabled users that join the inbound of the given protocol,
+// or of all the enabled users for no protocol; each user has a client for its main password, its previous password
+// in the grace period, and each of its credentials.
+// Clients of 2022 methods have no methods of their own, as they use the method of their inbounds.
+func (w *Writer) clients(protocol string) []*xray.Client {
 	var clients []*xray.Client
 	for _, u := range w.database.Content.Users {
-		if !u.Enabled {
+		if !u.Enabled || (protocol != "" && !w.database.Joins(u, protocol)) {
 			continue
 		}
+		method := u.ShadowsocksMethod
+		if database.Is2022(method) {
+			method = ""
+		}
 		clients = append(clients, &xray.Client{
-			Email:    strconv.Itoa(u.Id),
+			Email:    database.ClientEmail(u, nil),
 			Password: u.ShadowsocksPassword,
-			Method:   u.ShadowsocksMethod,
+			Method:   method,
 		})
+		if u.PreviousPassword != "" {
+			clients = append(clients, &xray.Client{
+				Email:    database.PreviousClientEmail(u),
+				Password: u.PreviousPassword,
+				Method:   method,
+			})
+		}
+		for _, c := range u.Credentials {
+			clients = append(clients, &xray.Client{
+				Email:    database.ClientEmail(u, c),
+				Password: c.Password,
+				Method:   method,
+			})
+		}
 	}
 	return clients
 }
 
+// inbound makes the Shadowsocks inbound of the protocol with the given clients.
+// Inbounds of 2022 methods use the server key, and the others use random keys, as their clients have their own.
+func (w *Writer) inbound(xc *xray.Config, protocol string, port int, clients []*xray.Client) (*xray.Inbound, error) {
+	method := w.database.InboundMethod(protocol)
+	if database.Is2022(method) {
+		return xc.MakeShadowsocksInbound(protocol, w.database.ServerKey(method), method, "tcp,udp", port, clients), nil
+	}
+
+	key, err := utils.Key32()
+	if err != nil {
+		return nil, err
+	}
+	return xc.MakeShadowsocksInbound(protocol, key, config.ShadowsocksMethod, "tcp,udp", port, clients), nil
+}
+
 func (w *Writer) LocalConfig() (*xray.Config, error) {
-	clients := w.clients()
+	clients := w.clients("")
 
 	apiPort, err := utils.FreePort()
 	if err != nil {
@@ -43,48 +79,27 @@ func (w *Writer) LocalConfig() (*xray.Config, error) {
 
 	xc := xray.NewConfig(w.c.Xray.LogLevel)
 	xc.FindInbound("api").Port = apiPort
+	// The access log tells the IPs of the users, see the tracker.
+	xc.Log.Access = w.c.Env.XrayAccessLogPath
 
 	var key string
 
 	if len(clients) > 0 {
-		if w.database.Content.Settings.SsRelayPort > 0 {
-			if key, err = utils.Key32(); err != nil {
-				return nil, err
+		for _, in := range []struct {
+			protocol string
+			port     int
+		}{
+			{database.ProtocolRelay, w.database.Content.Settings.SsRelayPort},
+			{database.ProtocolReverse, w.database.Content.Settings.SsReversePort},
+			{database.ProtocolDirect, w.database.Content.Settings.SsDirectPort},
+		} {
+			if pc := w.clients(in.protocol); in.port > 0 && len(pc) > 0 {
+				inbound, err := w.inbound(xc, in.protocol, in.port, pc)
+				if err != nil {
+					return nil, err
+				}
+				xc.Inbounds = append(xc.Inbounds, inbound)
 			}
-			xc.Inbounds = append(xc.Inbounds, xc.MakeShadowsocksInbound(
-				"relay",
-				key,
-				config.ShadowsocksMethod,
-				"tcp,udp",
-				w.database.Content.Settings.SsRelayPort,
-				clients,
-			))
-		}
-		if w.database.Content.Settings.SsReversePort > 0 {
-			if key, err = utils.Key32(); err != nil {
-				return nil, err
-			}
-			xc.Inbounds = append(xc.Inbounds, xc.MakeShadowsocksInbound(
-				"reverse",
-				key,
-				config.ShadowsocksMethod,
-				"tcp,udp",
-				w.database.Content.Settings.SsReversePort,
-				clients,
-			))
-		}
-		if w.database.Content.Settings.SsDirectPort > 0 {
-			if key, err = utils.Key32(); err != nil {
-				return nil, err
-			}
-			xc.Inbounds = append(xc.Inbounds, xc.MakeShadowsocksInbound(
-				"direct",
-				key,
-				config.ShadowsocksMethod,
-				"tcp,udp",
-				w.database.Content.Settings.SsDirectPort,
-				clients,
-			))
 		}
 	}
 
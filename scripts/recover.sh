@@ -14,9 +14,13 @@ SERVICE_NAME=$(basename "$ROOT")
 systemctl stop "$SERVICE_NAME"
 
 # Replace database with the last backup
-LAST_BACKUP=$(ls -t "$ROOT/storage/database/backup-"* 2>/dev/null | head -n 1)
+LAST_BACKUP=$(ls -t "$ROOT/storage/database/backup-"*.json "$ROOT/storage/database/backup-"*.json.gz 2>/dev/null | head -n 1)
 if [ -n "$LAST_BACKUP" ]; then
-  cp "$LAST_BACKUP" "$ROOT/storage/database/app.json"
+  if [[ "$LAST_BACKUP" == *.gz ]]; then
+    gunzip -c "$LAST_BACKUP" > "$ROOT/storage/database/app.json"
+  else
+    cp "$LAST_BACKUP" "$ROOT/storage/database/app.json"
+  fi
   echo "$LAST_BACKUP recovered successfully."
 else
     echo "No backup file found."